@@ -0,0 +1,183 @@
+package gosockjs
+
+import (
+	"crypto/tls"
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AffinityProxy is an http.Handler that sits in front of several gosockjs
+// servers and sends every request of a SockJS session to the same one. It
+// reads the {serverid} segment of SockJS urls: a serverid that names a backend
+// goes to that backend, and any other serverid is hashed to pick one. Clients
+// that are allowed to choose the serverid (the sockjs-client "server" option)
+// can therefore be pinned to a node whose Router has that ServerId, and
+// clients that pick one at random still stick to a single node.
+//
+// Requests that are not part of a session (greeting, info, iframe, raw
+// websocket) go to a backend chosen by the client's address. Every request
+// is passed on with the client's address added to X-Forwarded-For; list the
+// proxy in the backends' TrustedProxies for their limits to use it.
+type AffinityProxy struct {
+	baseUrl  string
+	backends []*affinityBackend
+	byId     map[string]*affinityBackend
+}
+
+type affinityBackend struct {
+	id    string
+	url   *url.URL
+	proxy *httputil.ReverseProxy
+}
+
+// NewAffinityProxy returns a proxy for SockJS services at baseUrl. backends
+// maps server ids to the base urls of the nodes, which serve the same baseUrl.
+func NewAffinityProxy(baseUrl string, backends map[string]string) (*AffinityProxy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("No backends")
+	}
+	p := &AffinityProxy{baseUrl: baseUrl, byId: make(map[string]*affinityBackend)}
+	for id, rawurl := range backends {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.New("Backend url must be http or https: " + rawurl)
+		}
+		b := &affinityBackend{id: id, url: u}
+		b.proxy = &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = u.Scheme
+				req.URL.Host = u.Host
+			},
+			// Streaming transports need their frames passed on promptly.
+			FlushInterval: time.Millisecond,
+		}
+		p.backends = append(p.backends, b)
+		p.byId[id] = b
+	}
+	// Hashing must not depend on map order.
+	sort.Sort(backendsById(p.backends))
+	return p, nil
+}
+
+type backendsById []*affinityBackend
+
+func (b backendsById) Len() int           { return len(b) }
+func (b backendsById) Less(i, j int) bool { return b[i].id < b[j].id }
+func (b backendsById) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// serverId extracts the serverid from a SockJS session url, or returns "".
+func (p *AffinityProxy) serverId(path string) string {
+	if !strings.HasPrefix(path, p.baseUrl+"/") {
+		return ""
+	}
+	parts := strings.Split(path[len(p.baseUrl)+1:], "/")
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[0]
+}
+
+func (p *AffinityProxy) pick(key string) *affinityBackend {
+	h := fnv.New32a()
+	io.WriteString(h, key)
+	return p.backends[h.Sum32()%uint32(len(p.backends))]
+}
+
+// backend chooses the backend for a request.
+func (p *AffinityProxy) backend(req *http.Request) *affinityBackend {
+	if id := p.serverId(req.URL.Path); id != "" {
+		if b := p.byId[id]; b != nil {
+			return b
+		}
+		return p.pick(id)
+	}
+	return p.pick(addrHost(req.RemoteAddr))
+}
+
+func (p *AffinityProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b := p.backend(req)
+	if strings.ToLower(req.Header.Get("Upgrade")) == "websocket" {
+		b.tunnel(w, req)
+		return
+	}
+	b.proxy.ServeHTTP(w, req)
+}
+
+// tunnel passes a websocket upgrade through to the backend, then copies
+// bytes both ways until either side hangs up.
+func (b *affinityBackend) tunnel(w http.ResponseWriter, req *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		errStatus(w, http.StatusInternalServerError)
+		return
+	}
+	var backend net.Conn
+	var err error
+	if b.url.Scheme == "https" {
+		backend, err = tls.Dial("tcp", hostPort(b.url), nil)
+	} else {
+		backend, err = net.Dial("tcp", hostPort(b.url))
+	}
+	if err != nil {
+		log.Println("AffinityProxy:", err)
+		errStatus(w, http.StatusBadGateway)
+		return
+	}
+	defer backend.Close()
+	// Say who the client is, as the ReverseProxy does for other requests.
+	forwardedFor := addrHost(req.RemoteAddr)
+	if prior := req.Header["X-Forwarded-For"]; len(prior) > 0 {
+		forwardedFor = strings.Join(prior, ", ") + ", " + forwardedFor
+	}
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	if err := req.Write(backend); err != nil {
+		log.Println("AffinityProxy:", err)
+		errStatus(w, http.StatusBadGateway)
+		return
+	}
+	client, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Println("AffinityProxy:", err)
+		return
+	}
+	defer client.Close()
+	// The client may already have sent frames that are sitting in the buffer.
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		if _, err := backend.Write(buffered); err != nil {
+			return
+		}
+	}
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(backend, client)
+		done <- true
+	}()
+	go func() {
+		io.Copy(client, backend)
+		done <- true
+	}()
+	<-done
+}
+
+func hostPort(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return u.Host + ":443"
+	}
+	return u.Host + ":80"
+}
@@ -0,0 +1,117 @@
+package gosockjs
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A server whose handler says which server it is, then echoes.
+func startNamedServer(name string) ServerWithRouter {
+	return startTestServer("/echo", func(c *Conn) {
+		io.WriteString(c, name)
+		io.Copy(c, c)
+	})
+}
+
+func TestAffinityProxy(t *testing.T) {
+	a := startNamedServer("a")
+	defer a.Close()
+	b := startNamedServer("b")
+	defer b.Close()
+	p, err := NewAffinityProxy("/echo", map[string]string{"a": a.URL, "b": b.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	baseUrl := proxy.URL + "/echo"
+
+	poll := func(turl string) string {
+		r, err := http.Post(turl+"/xhr", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, _ := bodyString(r)
+		return s
+	}
+
+	for _, name := range []string{"a", "b"} {
+		turl := baseUrl + "/" + name + "/" + name + "session"
+		if s := poll(turl); s != "o\n" {
+			t.Errorf("Open through proxy was %q", s)
+		}
+		if s := poll(turl); s != xhrMessage(name) {
+			t.Errorf("Session on %s was answered with %q", name, s)
+		}
+	}
+
+	// A random server id goes to the same place every time.
+	turl := baseUrl + "/123/random"
+	poll(turl)
+	first := poll(turl)
+	if first != xhrMessage("a") && first != xhrMessage("b") {
+		t.Fatalf("Random server id was answered with %q", first)
+	}
+	r, err := sendXhr(http.DefaultClient, turl, "hello")
+	if err != nil || r.StatusCode != http.StatusNoContent {
+		t.Errorf("xhr_send through proxy failed: %v", err)
+	}
+	if s := poll(turl); s != xhrMessage("hello") {
+		t.Errorf("Echo through proxy was %q", s)
+	}
+
+	// Websockets are tunnelled.
+	ws, err := websocket.Dial(wsUrl(baseUrl)+"/b/wssession/websocket", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Could not dial websocket through proxy: %v", err)
+	}
+	defer ws.Close()
+	for _, expected := range []string{"o", `a["b"]`} {
+		var m string
+		if err := websocket.Message.Receive(ws, &m); err != nil || m != expected {
+			t.Errorf("Websocket through proxy got %q with error %v, not %q", m, err, expected)
+		}
+	}
+	websocket.Message.Send(ws, `["ping"]`)
+	var m string
+	if err := websocket.Message.Receive(ws, &m); err != nil || !strings.Contains(m, "ping") {
+		t.Errorf("Websocket echo through proxy got %q with error %v", m, err)
+	}
+}
+
+func TestAffinityProxyForwardedFor(t *testing.T) {
+	forwarded := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded <- req.Header.Get("X-Forwarded-For")
+		errStatus(w, http.StatusNotFound)
+	}))
+	defer backend.Close()
+	p, err := NewAffinityProxy("/echo", map[string]string{"a": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, upgrade := range []string{"", "websocket"} {
+		req, _ := http.NewRequest("GET", proxy.URL+"/echo/a/abc/websocket", nil)
+		req.Close = true
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		if upgrade != "" {
+			req.Header.Set("Upgrade", upgrade)
+			req.Header.Set("Connection", "Upgrade")
+		}
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if f := <-forwarded; f != "10.0.0.1, 127.0.0.1" {
+			t.Errorf("Upgrade %q forwarded for %q", upgrade, f)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // Some utilities
@@ -39,8 +40,8 @@ type ServerWithRouter struct {
 }
 
 // Servers
-func startTestServer(baseUrl string, h Handler) ServerWithRouter {
-	r, err := NewRouter(baseUrl, h)
+func startTestServer(baseUrl string, h Handler, opts ...Option) ServerWithRouter {
+	r, err := NewRouter(baseUrl, h, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -48,11 +49,11 @@ func startTestServer(baseUrl string, h Handler) ServerWithRouter {
 	return ServerWithRouter{server, r}
 }
 
-func startEchoServer() (ServerWithRouter, string) {
+func startEchoServer(opts ...Option) (ServerWithRouter, string) {
 	echo := func(c *Conn) {
 		io.Copy(c, c)
 	}
-	server := startTestServer("/echo", echo)
+	server := startTestServer("/echo", echo, opts...)
 	return server, server.URL + "/echo"
 }
 
@@ -145,3 +146,73 @@ func TestEntropy(t *testing.T) {
 		entropies[entropy] = true
 	}
 }
+
+func TestSessionLimits(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.MaxSessions = 1
+	})
+	defer server.Close()
+
+	r, err := http.Post(baseUrl+"/000/abc/xhr", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bodyString(r); b != "o\n" {
+		t.Errorf("First session got %q", b)
+	}
+	r, err = http.Post(baseUrl+"/000/def/xhr", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bodyString(r); b != "o\n"+`c[3503,"Server busy"]`+"\n" {
+		t.Errorf("Session over the limit got %q", b)
+	}
+	r, err = http.Get(baseUrl + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Full server's info had status %d", r.StatusCode)
+	}
+
+	server.Router.removeSession("abc", server.Router.getSession("abc"))
+	r, err = http.Post(baseUrl+"/000/def/xhr", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bodyString(r); b != "o\n" {
+		t.Errorf("Session after one was removed got %q", b)
+	}
+}
+
+func TestClosedSessionUncounted(t *testing.T) {
+	server := startTestServer("/close", func(c *Conn) { c.Close() }, func(c *Config) {
+		c.MaxSessions = 1
+		c.DisconnectDelay = 50 * time.Millisecond
+	})
+	defer server.Close()
+	baseUrl := server.URL + "/close"
+
+	for _, expected := range []string{"o\n", `c[3000,"Go away!"]` + "\n"} {
+		r, err := http.Post(baseUrl+"/000/abc/xhr", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := bodyString(r); b != expected {
+			t.Errorf("Closed session got %q, not %q", b, expected)
+		}
+	}
+	// Once the closed session is removed, there is room for another.
+	time.Sleep(200 * time.Millisecond)
+	r, err := http.Post(baseUrl+"/000/def/xhr", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := bodyString(r); b != "o\n" {
+		t.Errorf("Session after one was closed got %q", b)
+	}
+	if s := server.Router.getSession("abc"); s != nil {
+		t.Errorf("Closed session was not removed")
+	}
+}
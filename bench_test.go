@@ -0,0 +1,56 @@
+package gosockjs_test
+
+import (
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"strings"
+	"testing"
+)
+
+// benchmarkTransport measures round trips of a message through an echo
+// session.
+func benchmarkTransport(b *testing.B, transport string) {
+	server := gosockjstest.NewServer("/echo", echo)
+	defer server.Close()
+	c, err := gosockjstest.Dial(server.BaseUrl, transport)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.ExpectOpen(); err != nil {
+		b.Fatal(err)
+	}
+	msg := strings.Repeat("x", 64)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Send(msg); err != nil {
+			b.Fatal(err)
+		}
+		if err := c.ExpectMessages(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWebsocket(b *testing.B)    { benchmarkTransport(b, gosockjstest.Websocket) }
+func BenchmarkXhrPolling(b *testing.B)   { benchmarkTransport(b, gosockjstest.XhrPolling) }
+func BenchmarkXhrStreaming(b *testing.B) { benchmarkTransport(b, gosockjstest.XhrStreaming) }
+func BenchmarkJsonp(b *testing.B)        { benchmarkTransport(b, gosockjstest.Jsonp) }
+func BenchmarkEventsource(b *testing.B)  { benchmarkTransport(b, gosockjstest.Eventsource) }
+func BenchmarkHtmlfile(b *testing.B)     { benchmarkTransport(b, gosockjstest.Htmlfile) }
+
+// BenchmarkSessionSetup measures opening a session over xhr_streaming.
+func BenchmarkSessionSetup(b *testing.B) {
+	server := gosockjstest.NewServer("/echo", echo)
+	defer server.Close()
+	for i := 0; i < b.N; i++ {
+		c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.XhrStreaming)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := c.ExpectOpen(); err != nil {
+			b.Fatal(err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Gosockjs-bench load tests a SockJS echo service, such as the /echo endpoint of
+test_server. It opens many sessions over the chosen transports, sends messages
+on each at a fixed rate, and reports session setup times, round trip
+latencies, throughput and errors for each transport:
+
+	go run ./cmd/gosockjs-bench -url http://127.0.0.1:8081/echo -sessions 2000 -transports websocket,xhr_streaming
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	baseUrl    = flag.String("url", "http://127.0.0.1:8081/echo", "url of the SockJS echo service")
+	nsessions  = flag.Int("sessions", 100, "number of sessions per transport")
+	transports = flag.String("transports", strings.Join(gosockjstest.Transports, ","), "comma-separated transports to use")
+	rate       = flag.Float64("rate", 1, "messages per second sent on each session")
+	size       = flag.Int("size", 32, "size of each message in bytes")
+	duration   = flag.Duration("duration", 10*time.Second, "how long to send messages")
+	rampUp     = flag.Duration("ramp", time.Second, "time over which to open the sessions")
+	timeout    = flag.Duration("timeout", 10*time.Second, "how long to wait for a reply")
+)
+
+// stats are the results for one transport.
+type stats struct {
+	lock     sync.Mutex
+	setup    []time.Duration
+	latency  []time.Duration
+	sent     int
+	received int
+	bytes    int
+	errors   map[string]int
+	start    time.Time
+	end      time.Time
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) addError(what string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.errors[what+": "+err.Error()]++
+}
+
+// message makes a message of the given size that starts with its sequence
+// number, so the echo can be matched to it.
+func message(seq int) string {
+	m := strconv.Itoa(seq) + " "
+	if len(m) < *size {
+		m += strings.Repeat("x", *size-len(m))
+	}
+	return m
+}
+
+func messageSeq(m string) (int, bool) {
+	i := strings.Index(m, " ")
+	if i < 0 {
+		return 0, false
+	}
+	seq, err := strconv.Atoi(m[:i])
+	return seq, err == nil
+}
+
+// runSession opens one session, then sends and receives until stop is closed.
+func runSession(transport string, s *stats, stop chan struct{}) {
+	t0 := time.Now()
+	c, err := gosockjstest.Dial(*baseUrl, transport)
+	if err == nil {
+		c.Timeout = *timeout
+		err = c.ExpectOpen()
+	}
+	if err != nil {
+		s.addError("open", err)
+		return
+	}
+	defer c.Close()
+	s.lock.Lock()
+	s.setup = append(s.setup, time.Since(t0))
+	s.lock.Unlock()
+
+	var sentLock sync.Mutex
+	sentAt := make(map[int]time.Time)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				select {
+				case <-stop:
+				default:
+					s.addError("receive", err)
+				}
+				return
+			}
+			now := time.Now()
+			seq, ok := messageSeq(m)
+			sentLock.Lock()
+			t, found := sentAt[seq]
+			delete(sentAt, seq)
+			sentLock.Unlock()
+			if !ok || !found {
+				s.addError("receive", fmt.Errorf("unexpected message"))
+				continue
+			}
+			s.lock.Lock()
+			s.latency = append(s.latency, now.Sub(t))
+			s.received++
+			s.bytes += len(m)
+			s.lock.Unlock()
+		}
+	}()
+
+	tick := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer tick.Stop()
+	for seq := 0; ; seq++ {
+		select {
+		case <-stop:
+			c.Close()
+			<-readerDone
+			return
+		case <-readerDone:
+			return
+		case <-tick.C:
+		}
+		sentLock.Lock()
+		sentAt[seq] = time.Now()
+		sentLock.Unlock()
+		if err := c.Send(message(seq)); err != nil {
+			s.addError("send", err)
+			continue
+		}
+		s.lock.Lock()
+		s.sent++
+		s.lock.Unlock()
+	}
+}
+
+// run benchmarks one transport.
+func run(transport string) *stats {
+	s := newStats()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var interval time.Duration
+	if *nsessions > 0 {
+		interval = *rampUp / time.Duration(*nsessions)
+	}
+	s.start = time.Now()
+	for i := 0; i < *nsessions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSession(transport, s, stop)
+		}()
+		time.Sleep(interval)
+	}
+	time.Sleep(*duration)
+	close(stop)
+	s.end = time.Now()
+	wg.Wait()
+	return s
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// percentile returns the pth percentile of sorted durations.
+func percentile(d []time.Duration, p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	i := int(float64(len(d)-1) * p / 100)
+	return d[i]
+}
+
+func summary(d []time.Duration) string {
+	if len(d) == 0 {
+		return "none"
+	}
+	sort.Sort(durations(d))
+	return fmt.Sprintf("p50 %v  p90 %v  p99 %v  max %v",
+		percentile(d, 50), percentile(d, 90), percentile(d, 99), d[len(d)-1])
+}
+
+func (s *stats) report(transport string) {
+	elapsed := s.end.Sub(s.start).Seconds()
+	fmt.Printf("%s\n", transport)
+	fmt.Printf("  sessions:   %d of %d opened\n", len(s.setup), *nsessions)
+	fmt.Printf("  setup:      %s\n", summary(s.setup))
+	fmt.Printf("  latency:    %s\n", summary(s.latency))
+	fmt.Printf("  throughput: %.1f msgs/s, %.1f KB/s (%d sent, %d received)\n",
+		float64(s.received)/elapsed, float64(s.bytes)/elapsed/1024, s.sent, s.received)
+	var nerrors int
+	for _, n := range s.errors {
+		nerrors += n
+	}
+	fmt.Printf("  errors:     %d\n", nerrors)
+	var msgs []string
+	for m := range s.errors {
+		msgs = append(msgs, m)
+	}
+	sort.Strings(msgs)
+	for _, m := range msgs {
+		fmt.Printf("    %6d %s\n", s.errors[m], m)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *rate <= 0 || *nsessions < 0 || *size < 0 {
+		fmt.Fprintln(os.Stderr, "rate must be positive, and sessions and size not negative")
+		os.Exit(2)
+	}
+	// gosockjs closes polling and streaming connections, so reusing
+	// connections only gives spurious errors.
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.DisableKeepAlives = true
+	}
+	known := make(map[string]bool)
+	for _, t := range gosockjstest.Transports {
+		known[t] = true
+	}
+	ts := strings.Split(*transports, ",")
+	for _, t := range ts {
+		if !known[t] {
+			log.Fatalf("Unknown transport %q; use one of %s", t, strings.Join(gosockjstest.Transports, ","))
+		}
+	}
+	for _, t := range ts {
+		run(t).report(t)
+	}
+}
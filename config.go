@@ -0,0 +1,299 @@
+package gosockjs
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of a Router.
+type Config struct {
+	// WebsocketEnabled controls the websocket transport.
+	WebsocketEnabled bool
+	// CookieNeeded sets a sticky-session cookie on every response. See Cookie.
+	CookieNeeded bool
+	// DisconnectDelay is how long a session without a receiving connection
+	// lives, and HeartbeatDelay how often a receiving connection gets a
+	// heartbeat. Both must be positive.
+	DisconnectDelay time.Duration
+	HeartbeatDelay  time.Duration
+	// BinaryMode is the initial binary mode of new connections. See Conn.SetBinary.
+	BinaryMode bool
+	// RawWebsocketEnabled controls the raw websocket endpoint, independently
+	// of WebsocketEnabled.
+	RawWebsocketEnabled bool
+	// These control the other transports. The endpoints of a disabled
+	// transport are not found. IframeEnabled controls the iframe page, and so
+	// every iframe-based transport. At least one transport must be enabled.
+	XhrPollingEnabled   bool
+	XhrStreamingEnabled bool
+	JsonpEnabled        bool
+	EventsourceEnabled  bool
+	HtmlfileEnabled     bool
+	IframeEnabled       bool
+	// InfoTransports adds a "transports" list of the enabled transports, by
+	// their sockjs-client names, to /info. It is not part of the protocol,
+	// and SockJS clients ignore it: the only transport they learn is
+	// disabled is websocket, from /info's "websocket". They still try the
+	// others, and fall back from those that are not found, so pages should
+	// list the enabled ones in the client's protocols_whitelist option (0.3)
+	// or transports option (1.x).
+	InfoTransports bool
+	// EventsourceRetry is the reconnection delay suggested to eventsource
+	// clients. If it is positive message frames carry event ids, and a
+	// client that reconnects with a Last-Event-ID header is sent the frames
+	// it missed; a dropped eventsource connection leaves the session open
+	// until DisconnectDelay. Zero, the default, gives SockJS's plain
+	// framing.
+	EventsourceRetry time.Duration
+	// FrameAncestors lists the sources, in Content-Security-Policy syntax,
+	// of the pages that may frame the iframe and htmlfile pages, such as
+	// "https://example.com" or "'self'". By default any page may.
+	FrameAncestors []string
+	// The limits at which the streaming transports close a response, for
+	// the client to open another. The default is SockJS's 4096 bytes.
+	XhrStreamingLimit StreamLimit
+	EventsourceLimit  StreamLimit
+	HtmlfileLimit     StreamLimit
+	// WriteTimeout bounds each write to a client's connection. A connection
+	// that takes longer, because the client has stopped reading, is dropped;
+	// messages it did not take stay queued for the session's next one. Zero
+	// means no limit.
+	WriteTimeout time.Duration
+	// RawProtocols lists the websocket sub-protocols the raw endpoint
+	// supports, in order of preference.
+	RawProtocols []string
+	// RawHandler is the handler for connections on the raw websocket
+	// endpoint. If it is nil, raw connections go to the same handler as
+	// SockJS sessions.
+	RawHandler Handler
+	// The interceptors of messages from and to clients, in the order they
+	// run. See InterceptInbound and InterceptOutbound.
+	InboundInterceptors  []Interceptor
+	OutboundInterceptors []Interceptor
+	// Limits on inbound messages and bytes for each session, and what to do
+	// about sessions that go over them.
+	MessageRateLimit RateLimit
+	ByteRateLimit    RateLimit
+	RateLimitPolicy  RateLimitPolicy
+	// SessionRateLimit limits how fast each remote address may open sessions.
+	SessionRateLimit RateLimit
+	// MaxSessions caps the number of open sessions, and MaxSessionsPerClient
+	// the number from any one remote address. Zero means no limit.
+	MaxSessions          int
+	MaxSessionsPerClient int
+	// TrustedProxies lists the addresses, as IPs or CIDR ranges, of proxies
+	// in front of the router, such as an AffinityProxy. A request from one
+	// of them counts against the limits of the client its X-Forwarded-For
+	// header names. By default the header is ignored.
+	TrustedProxies []string
+	// ServerId names this node. It is the value of the sticky-session cookie,
+	// and should be the name an AffinityProxy knows the node by, so that the
+	// sessions whose urls use it as their serverid come here.
+	ServerId string
+	// Cookie configures the sticky-session cookie. See CookieNeeded.
+	Cookie CookieOptions
+	// ClientVersion is the release of sockjs-client, such as "1.6.1", that
+	// the pages use. It is the version of the client script the hidden
+	// iframe loads when its url does not give one, which 1.x clients need.
+	// By default the iframe loads the latest 0.3 client.
+	ClientVersion string
+	// SockjsUrl, if set, is the client script loaded by the iframe, instead
+	// of one on a CDN for ClientVersion. It must be the same version as the
+	// pages' client.
+	SockjsUrl string
+	// InfoBaseUrl, if set, is sent in /info as base_url: the url, such as
+	// that of one node, that 1.x clients open their sessions at instead of
+	// the one they were given. 0.3 clients ignore it, so it needs a 1.x
+	// ClientVersion.
+	InfoBaseUrl string
+}
+
+// StreamLimit says when a streaming response is closed: after it has
+// written MaxBytes, or been open for MaxDuration. Zero means no limit.
+// Responses that stay open too long may be buffered or cut off by proxies.
+type StreamLimit struct {
+	MaxBytes    int
+	MaxDuration time.Duration
+}
+
+// DefaultConfig returns the configuration NewRouter starts from.
+func DefaultConfig() Config {
+	return Config{
+		WebsocketEnabled:    true,
+		RawWebsocketEnabled: true,
+		XhrPollingEnabled:   true,
+		XhrStreamingEnabled: true,
+		JsonpEnabled:        true,
+		EventsourceEnabled:  true,
+		HtmlfileEnabled:     true,
+		IframeEnabled:       true,
+		XhrStreamingLimit:   StreamLimit{MaxBytes: 4096},
+		EventsourceLimit:    StreamLimit{MaxBytes: 4096},
+		HtmlfileLimit:       StreamLimit{MaxBytes: 4096},
+		WriteTimeout:        10 * time.Second,
+		DisconnectDelay:     time.Second * 5,
+		HeartbeatDelay:      time.Second * 25,
+	}
+}
+
+// An Option changes a Config. Options are applied in order, so a
+// configuration can be built up like
+//
+//	r, err := gosockjs.NewRouter("/echo", echo, gosockjs.WithConfig(base), func(c *gosockjs.Config) {
+//		c.CookieNeeded = true
+//	})
+type Option func(c *Config)
+
+// WithConfig replaces the whole configuration with c.
+func WithConfig(c Config) Option {
+	return func(config *Config) {
+		*config = c.clone()
+	}
+}
+
+// WithRawHandler sets the handler for raw websocket connections.
+func WithRawHandler(h Handler) Option {
+	return func(c *Config) {
+		c.RawHandler = h
+	}
+}
+
+// Validate checks that the configuration makes sense.
+func (c *Config) Validate() error {
+	if c.DisconnectDelay <= 0 {
+		return fmt.Errorf("DisconnectDelay must be positive, not %v.", c.DisconnectDelay)
+	}
+	if c.HeartbeatDelay <= 0 {
+		return fmt.Errorf("HeartbeatDelay must be positive, not %v.", c.HeartbeatDelay)
+	}
+	for name, l := range map[string]RateLimit{
+		"MessageRateLimit": c.MessageRateLimit,
+		"ByteRateLimit":    c.ByteRateLimit,
+		"SessionRateLimit": c.SessionRateLimit,
+	} {
+		if l.Rate < 0 || l.Burst < 0 {
+			return fmt.Errorf("%s must not be negative, not %+v.", name, l)
+		}
+	}
+	if c.RateLimitPolicy < RateLimitReject || c.RateLimitPolicy > RateLimitClose {
+		return fmt.Errorf("Unknown RateLimitPolicy %d.", c.RateLimitPolicy)
+	}
+	if c.EventsourceRetry < 0 {
+		return fmt.Errorf("EventsourceRetry must not be negative, not %v.", c.EventsourceRetry)
+	}
+	for name, l := range map[string]StreamLimit{
+		"XhrStreamingLimit": c.XhrStreamingLimit,
+		"EventsourceLimit":  c.EventsourceLimit,
+		"HtmlfileLimit":     c.HtmlfileLimit,
+	} {
+		if l.MaxBytes < 0 || l.MaxDuration < 0 {
+			return fmt.Errorf("%s must not be negative, not %+v.", name, l)
+		}
+	}
+	if len(c.transports()) == 0 && !c.RawWebsocketEnabled {
+		return fmt.Errorf("At least one transport must be enabled.")
+	}
+	if c.WriteTimeout < 0 {
+		return fmt.Errorf("WriteTimeout must not be negative, not %v.", c.WriteTimeout)
+	}
+	for _, source := range c.FrameAncestors {
+		if source == "" || strings.ContainsAny(source, " \t\r\n;,") {
+			return fmt.Errorf("Bad FrameAncestors source %q.", source)
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		if err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("Bad TrustedProxies address %q.", proxy)
+		}
+	}
+	if c.MaxSessions < 0 || c.MaxSessionsPerClient < 0 {
+		return fmt.Errorf("Session limits must not be negative.")
+	}
+	if c.ClientVersion != "" && clientMajor(c.ClientVersion) == "" {
+		return fmt.Errorf("ClientVersion must be a release like 1.6.1, not %q.", c.ClientVersion)
+	}
+	if c.InfoBaseUrl != "" {
+		if m := clientMajor(c.ClientVersion); m == "" || m == "0" {
+			return fmt.Errorf("InfoBaseUrl is only used by 1.x clients; set a 1.x ClientVersion.")
+		}
+	}
+	if c.Cookie.MaxAge < 0 {
+		return fmt.Errorf("Cookie.MaxAge must not be negative.")
+	}
+	if c.Cookie.SameSite == http.SameSiteNoneMode && !c.Cookie.Secure {
+		// Browsers reject such cookies.
+		return fmt.Errorf("Cookie.SameSite None needs Cookie.Secure.")
+	}
+	return nil
+}
+
+// validBaseUrl checks that a base url is empty, for relative routing, or a
+// clean absolute path without a trailing slash.
+func validBaseUrl(baseUrl string) error {
+	if baseUrl == "" {
+		return nil
+	}
+	if baseUrl[0] != '/' || baseUrl == "/" || path.Clean(baseUrl) != baseUrl {
+		return fmt.Errorf("Bad base url %q: it should be empty or an absolute path without a trailing slash.", baseUrl)
+	}
+	return nil
+}
+
+// Config returns a copy of the router's configuration, which is fixed when
+// it is made.
+func (r *Router) Config() Config {
+	return r.config.clone()
+}
+
+// clone copies a Config, including its slices, so that neither copy can
+// change the other.
+func (c Config) clone() Config {
+	c.FrameAncestors = append([]string(nil), c.FrameAncestors...)
+	c.RawProtocols = append([]string(nil), c.RawProtocols...)
+	c.TrustedProxies = append([]string(nil), c.TrustedProxies...)
+	c.InboundInterceptors = append([]Interceptor(nil), c.InboundInterceptors...)
+	c.OutboundInterceptors = append([]Interceptor(nil), c.OutboundInterceptors...)
+	return c
+}
+
+// trustedProxy is true if host is one of the TrustedProxies.
+func (c *Config) trustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, ipnet, err := net.ParseCIDR(proxy); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// transports lists the sockjs-client names of the enabled transports.
+func (c *Config) transports() []string {
+	var ts []string
+	add := func(enabled bool, names ...string) {
+		if enabled {
+			ts = append(ts, names...)
+		}
+	}
+	add(c.WebsocketEnabled, "websocket")
+	add(c.XhrStreamingEnabled, "xhr-streaming")
+	add(c.EventsourceEnabled, "eventsource")
+	add(c.IframeEnabled && c.EventsourceEnabled, "iframe-eventsource")
+	add(c.IframeEnabled && c.HtmlfileEnabled, "iframe-htmlfile")
+	add(c.XhrPollingEnabled, "xhr-polling")
+	add(c.IframeEnabled && c.XhrPollingEnabled, "iframe-xhr-polling")
+	add(c.JsonpEnabled, "jsonp-polling")
+	return ts
+}
@@ -0,0 +1,115 @@
+package gosockjs
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConfigValidation(t *testing.T) {
+	echo := func(c *Conn) {}
+	bad := []struct {
+		baseUrl string
+		opt     Option
+	}{
+		{"echo", nil},
+		{"/echo/", nil},
+		{"/", nil},
+		{"/a//b", nil},
+		{"/echo", func(c *Config) { c.DisconnectDelay = 0 }},
+		{"/echo", func(c *Config) { c.HeartbeatDelay = -time.Second }},
+		{"/echo", func(c *Config) { c.MaxSessions = -1 }},
+		{"/echo", func(c *Config) { c.ByteRateLimit.Rate = -1 }},
+		{"/echo", func(c *Config) { c.RateLimitPolicy = 7 }},
+		{"/echo", func(c *Config) { c.ClientVersion = "1" }},
+		{"/echo", func(c *Config) { c.InfoBaseUrl = "http://node1.example.com/echo" }},
+		{"/echo", func(c *Config) {
+			c.ClientVersion = "0.3.4"
+			c.InfoBaseUrl = "http://node1.example.com/echo"
+		}},
+		{"/echo", func(c *Config) { c.WriteTimeout = -time.Second }},
+		{"/echo", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/33"} }},
+		{"/echo", func(c *Config) { c.Cookie.SameSite = http.SameSiteNoneMode }},
+		{"/echo", WithConfig(Config{})},
+		{"/echo", func(c *Config) {
+			c.WebsocketEnabled = false
+			c.RawWebsocketEnabled = false
+			c.XhrPollingEnabled = false
+			c.XhrStreamingEnabled = false
+			c.JsonpEnabled = false
+			c.EventsourceEnabled = false
+			c.HtmlfileEnabled = false
+		}},
+	}
+	for _, test := range bad {
+		var opts []Option
+		if test.opt != nil {
+			opts = append(opts, test.opt)
+		}
+		if _, err := NewRouter(test.baseUrl, echo, opts...); err == nil {
+			t.Errorf("NewRouter(%q) with a bad option gave no error", test.baseUrl)
+		}
+	}
+
+	r, err := NewRouter("", echo, func(c *Config) { c.HeartbeatDelay = time.Second }, func(c *Config) { c.CookieNeeded = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.config.HeartbeatDelay != time.Second || !r.config.CookieNeeded || r.config.DisconnectDelay != 5*time.Second {
+		t.Errorf("Options gave %+v", r.config)
+	}
+}
+
+func TestConfigCopy(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.FrameAncestors = []string{"'self'"}
+	})
+	defer server.Close()
+	c := server.Router.Config()
+	if c.FrameAncestors[0] != "'self'" || c.DisconnectDelay != 5*time.Second {
+		t.Errorf("Config gave %+v", c)
+	}
+	// Changing the copy changes nothing.
+	c.CookieNeeded = true
+	c.FrameAncestors[0] = "*"
+	r, err := http.Get(baseUrl + "/iframe.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.Header.Get("Set-Cookie") != "" || r.Header.Get("Content-Security-Policy") != "frame-ancestors 'self'" {
+		t.Errorf("Changing the copy changed the router")
+	}
+
+	// Nor does changing the config a router was made from, and routers
+	// made from the same config don't share its slices.
+	base := DefaultConfig()
+	base.FrameAncestors = []string{"'self'"}
+	base.InboundInterceptors = make([]Interceptor, 0, 4)
+	mark := func(s string) Interceptor {
+		return func(c *Conn, m []byte) ([]byte, error) { return append(m, s...), nil }
+	}
+	echo := func(c *Conn) {}
+	r1, err := NewRouter("/echo", echo, WithConfig(base), InterceptInbound(mark("1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := NewRouter("/echo", echo, WithConfig(base), InterceptInbound(mark("2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.FrameAncestors[0] = "*"
+	if r1.config.FrameAncestors[0] != "'self'" {
+		t.Errorf("Changing the base config changed the router")
+	}
+	for i, r := range []*Router{r1, r2} {
+		chain := r.config.InboundInterceptors
+		if len(chain) != 1 {
+			t.Fatalf("Router %d has %d interceptors", i+1, len(chain))
+		}
+		if m, _ := chain[0](nil, nil); string(m) != fmt.Sprint(i+1) {
+			t.Errorf("Router %d has router %s's interceptor", i+1, m)
+		}
+	}
+}
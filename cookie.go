@@ -0,0 +1,82 @@
+package gosockjs
+
+import (
+	"net/http"
+)
+
+// CookieOptions configures the sticky-session cookie that load balancers use
+// for affinity. A cookie the client already has is always sent back; a new
+// one is only set when the Router's CookieNeeded is true.
+type CookieOptions struct {
+	// Name defaults to JSESSIONID.
+	Name string
+	// Value generates the value of a new cookie. By default it is the
+	// router's ServerId, or "dummy" if that is empty.
+	Value func(req *http.Request) string
+	// Path defaults to "/".
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	// SameSite None requires Secure.
+	SameSite http.SameSite
+	// MaxAge is as for http.Cookie: zero means a session cookie.
+	MaxAge int
+}
+
+func (r *Router) cookieName() string {
+	if r.config.Cookie.Name != "" {
+		return r.config.Cookie.Name
+	}
+	return "JSESSIONID"
+}
+
+// sessionCookie returns the cookie to set on a response, or nil if there is none.
+func (r *Router) sessionCookie(req *http.Request) *http.Cookie {
+	opts := r.config.Cookie
+	c := &http.Cookie{
+		Name:     r.cookieName(),
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   opts.Secure,
+		HttpOnly: opts.HttpOnly,
+		SameSite: opts.SameSite,
+		MaxAge:   opts.MaxAge,
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if old, err := req.Cookie(c.Name); err == nil && old != nil {
+		c.Value = old.Value
+	} else if r.config.CookieNeeded {
+		switch {
+		case opts.Value != nil:
+			c.Value = opts.Value(req)
+		case r.config.ServerId != "":
+			c.Value = r.config.ServerId
+		default:
+			c.Value = "dummy"
+		}
+	} else {
+		return nil
+	}
+	return c
+}
+
+func writeSessionCookie(r *Router, w http.ResponseWriter, req *http.Request) {
+	if c := r.sessionCookie(req); c != nil {
+		http.SetCookie(w, c)
+	}
+}
+
+// sessionCookieHeader is the cookie for a websocket handshake response, as
+// a header to add to it.
+func (r *Router) sessionCookieHeader(req *http.Request) http.Header {
+	c := r.sessionCookie(req)
+	if c == nil {
+		return nil
+	}
+	h := make(http.Header)
+	h.Set("Set-Cookie", c.String())
+	return h
+}
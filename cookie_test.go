@@ -0,0 +1,98 @@
+package gosockjs
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSessionCookie(t *testing.T) {
+	server, baseUrl := startEchoServer()
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", baseUrl+"/000/abc/xhr", nil)
+	req.Close = true
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if c := r.Header.Get("Set-Cookie"); c != "" {
+		t.Errorf("Cookie %q set when none was needed", c)
+	}
+
+	cookies := func(c *Config) {
+		c.CookieNeeded = true
+		c.ServerId = "node1"
+		c.Cookie = CookieOptions{
+			Name:     "affinity",
+			Domain:   "example.com",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteNoneMode,
+			MaxAge:   60,
+		}
+	}
+	server, baseUrl = startEchoServer(cookies)
+	defer server.Close()
+	expected := "affinity=node1; Path=/; Domain=example.com; Max-Age=60; HttpOnly; Secure; SameSite=None"
+	for _, u := range []string{"/000/def/xhr", "/000/ghi/xhr_streaming", "/info"} {
+		method := "POST"
+		if u == "/info" {
+			method = "GET"
+		}
+		// Hijacked responses close their connections.
+		req, _ := http.NewRequest(method, baseUrl+u, nil)
+		req.Close = true
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if c := r.Header.Get("Set-Cookie"); c != expected {
+			t.Errorf("%s set cookie %q, not %q", u, c, expected)
+		}
+	}
+
+	// An existing cookie is sent back.
+	req, _ = http.NewRequest("GET", baseUrl+"/info", nil)
+	req.AddCookie(&http.Cookie{Name: "affinity", Value: "node2"})
+	r, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if c := r.Cookies(); len(c) != 1 || c[0].Value != "node2" {
+		t.Errorf("Existing cookie came back as %v", c)
+	}
+
+	// As does a websocket handshake.
+	server, baseUrl = startEchoServer(cookies, func(c *Config) {
+		c.Cookie.Value = func(req *http.Request) string { return "generated" }
+	})
+	defer server.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ = http.NewRequest("GET", baseUrl+"/000/jkl/websocket", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Origin", "http://localhost/")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Write(conn)
+	r, err = http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Websocket handshake had status %d", r.StatusCode)
+	}
+	if c := r.Cookies(); len(c) != 1 || c[0].Value != "generated" {
+		t.Errorf("Websocket cookie was %v", c)
+	}
+}
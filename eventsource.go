@@ -4,9 +4,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type eventsourceOptions struct {
+	streamLimit
+	// retry is the reconnection delay suggested to the client. If it is
+	// zero message frames have no ids.
+	retry time.Duration
 }
 
 func (o eventsourceOptions) writeFrame(w io.Writer, frame []byte) error {
@@ -14,15 +21,21 @@ func (o eventsourceOptions) writeFrame(w io.Writer, frame []byte) error {
 	return err
 }
 
-func (o eventsourceOptions) contentType() string {
-	return "text/event-stream; charset=UTF-8"
+// writeEvent writes a frame with its event id.
+func (o eventsourceOptions) writeEvent(w io.Writer, id uint64, frame []byte) error {
+	_, err := fmt.Fprintf(w, "id: %d\r\ndata: %s\r\n\r\n", id, frame)
+	return err
 }
 
-func (o eventsourceOptions) maxBytes() int {
-	return 4096
+func (o eventsourceOptions) contentType() string {
+	return "text/event-stream; charset=UTF-8"
 }
 
 func (o eventsourceOptions) writePrelude(w io.Writer) error {
+	if o.retry > 0 {
+		_, err := fmt.Fprintf(w, "retry: %d\r\n\r\n", o.retry/time.Millisecond)
+		return err
+	}
 	_, err := io.WriteString(w, "\r\n")
 	return err
 }
@@ -31,6 +44,74 @@ func (o eventsourceOptions) streaming() bool {
 	return true
 }
 
+// The number of message frames kept for receivers that reconnect.
+const eventHistorySize = 128
+
+// eventHistory numbers the message frames of an eventsource session, and
+// keeps the recent ones to replay to a receiver that reconnects with the id
+// of the last one it got.
+type eventHistory struct {
+	opts   eventsourceOptions
+	lock   sync.Mutex
+	lastId uint64
+	frames []eventFrame
+}
+
+type eventFrame struct {
+	id    uint64
+	frame []byte
+}
+
+// send writes a frame to w, with an id if it carries messages.
+func (h *eventHistory) send(w io.Writer, frame []byte) error {
+	if len(frame) == 0 || frame[0] != 'a' {
+		return h.opts.writeFrame(w, frame)
+	}
+	// Hold the lock while writing, so ids go out in order.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	id := h.lastId + 1
+	if err := h.opts.writeEvent(w, id, frame); err != nil {
+		// The frame stays in the outbox, and gets this id when it is sent.
+		return err
+	}
+	h.lastId = id
+	h.frames = append(h.frames, eventFrame{id, frame})
+	if n := len(h.frames) - eventHistorySize; n > 0 {
+		h.frames = h.frames[n:]
+	}
+	return nil
+}
+
+// replay writes the frames after lastEventId, the value of a Last-Event-ID
+// header, to w. The ones before it have been delivered and are forgotten.
+func (h *eventHistory) replay(w io.Writer, lastEventId string) error {
+	last, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		// A new receiver, or not one of our ids.
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var kept []eventFrame
+	for _, f := range h.frames {
+		if f.id > last {
+			kept = append(kept, f)
+		}
+	}
+	h.frames = kept
+	for _, f := range kept {
+		if err := h.opts.writeEvent(w, f.id, f.frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func eventsourceHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	xhrHandlerBase(eventsourceOptions{}, r, w, req)
+	opts := eventsourceOptions{
+		streamLimit: streamLimit{"eventsource", r.config.EventsourceLimit},
+		retry:       r.config.EventsourceRetry,
+	}
+	xhrHandlerBase(opts, r, w, req)
 }
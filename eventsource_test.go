@@ -0,0 +1,72 @@
+package gosockjs
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openEventsource opens an eventsource receiver, resuming after lastId if it
+// is not empty.
+func openEventsource(t *testing.T, u, lastId string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", u+"/eventsource", nil)
+	req.Close = true
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, bufio.NewReader(r.Body)
+}
+
+func expectEvent(t *testing.T, br *bufio.Reader, expected string) {
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != expected {
+		t.Errorf("Expected %q, got %q, %v", expected, buf, err)
+	}
+}
+
+func xhrSend(t *testing.T, u, body string) {
+	req, _ := http.NewRequest("POST", u+"/xhr_send", strings.NewReader(body))
+	req.Close = true
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNoContent {
+		t.Errorf("xhr_send gave %d", r.StatusCode)
+	}
+}
+
+func TestEventsourceResume(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.EventsourceRetry = time.Second
+	})
+	defer server.Close()
+	u := baseUrl + "/000/resume"
+
+	r, br := openEventsource(t, u, "")
+	expectEvent(t, br, "retry: 1000\r\n\r\n")
+	expectEvent(t, br, "data: o\r\n\r\n")
+	xhrSend(t, u, `["x"]`)
+	expectEvent(t, br, "id: 1\r\ndata: a[\"x\"]\r\n\r\n")
+	xhrSend(t, u, `["y"]`)
+	expectEvent(t, br, "id: 2\r\ndata: a[\"y\"]\r\n\r\n")
+	// The connection drops, and the session lives on. The client may
+	// reconnect before the server notices.
+	r.Body.Close()
+
+	// Suppose the client only got the first frame.
+	r, br = openEventsource(t, u, "1")
+	defer r.Body.Close()
+	expectEvent(t, br, "retry: 1000\r\n\r\n")
+	expectEvent(t, br, "id: 2\r\ndata: a[\"y\"]\r\n\r\n")
+	xhrSend(t, u, `["z"]`)
+	expectEvent(t, br, "id: 3\r\ndata: a[\"z\"]\r\n\r\n")
+}
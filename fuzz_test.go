@@ -0,0 +1,178 @@
+package gosockjs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// isEscapable reports whether a character must be escaped in frames sent to
+// clients, because some browsers mangle it.
+func isEscapable(c rune) bool {
+	return c < 0x20 ||
+		(c >= 0x200c && c <= 0x200f) ||
+		(c >= 0x2028 && c <= 0x202f) ||
+		(c >= 0x2060 && c <= 0x206f) ||
+		(c >= 0xfff0 && c <= 0xffff)
+}
+
+// checkEscaped checks that js is valid JSON with no raw escapable characters.
+func checkEscaped(t *testing.T, js []byte) {
+	if !utf8.Valid(js) {
+		t.Fatalf("%q is not valid UTF-8", js)
+	}
+	if !json.Valid(js) {
+		t.Fatalf("%q is not valid JSON", js)
+	}
+	for _, c := range string(js) {
+		if isEscapable(c) {
+			t.Fatalf("%q contains unescaped %U", js, c)
+		}
+	}
+}
+
+func FuzzFromClient(f *testing.F) {
+	for _, seed := range []string{
+		`"abc"`, `["abc","def"]`, ` ["abc"]`, `[]`, `""`, `null`, `[`, `"`,
+		`[1,2]`, `{"a":"b"}`, `["\u0000\ud800"]`, "\t\r\n\"x\"", `[""`,
+	} {
+		f.Add(seed)
+	}
+	r := &Router{config: Config{DisconnectDelay: time.Minute}}
+	f.Fuzz(func(t *testing.T, payload string) {
+		s := newSession(r, "", "")
+		s.trans = new(recordingTransport)
+		defer s.Close()
+		err := s.fromClient(message(payload))
+
+		var got []string
+		for len(s.readQueue) > 0 {
+			got = append(got, string(<-s.readQueue))
+		}
+		if payload == "" {
+			if err != nil || got != nil {
+				t.Fatalf("Empty payload gave %q, %v", got, err)
+			}
+			return
+		}
+
+		// A payload is accepted if and only if it is a string or an array of
+		// strings, and its messages are queued in order.
+		var v interface{}
+		var expected []string
+		json.Unmarshal([]byte(payload), &v)
+		switch v := v.(type) {
+		case nil:
+			// Broken JSON, or null, which is not worth caring about.
+			if err != nil && err != JSONError {
+				t.Fatalf("Payload %q gave error %v", payload, err)
+			}
+			return
+		case string:
+			expected = []string{v}
+		case []interface{}:
+			for _, elt := range v {
+				str, ok := elt.(string)
+				if !ok {
+					expected = nil
+					break
+				}
+				expected = append(expected, str)
+			}
+			if len(expected) != len(v) {
+				expected = nil
+			} else if expected == nil {
+				expected = []string{}
+			}
+		}
+		switch {
+		case expected == nil:
+			if err != JSONError || got != nil {
+				t.Fatalf("Bad payload %q gave %q, %v", payload, got, err)
+			}
+		case len(expected) > cap(s.readQueue):
+			// Too many to queue.
+		case err != nil || len(got) != len(expected) || (len(got) > 0 && !reflect.DeepEqual(got, expected)):
+			t.Fatalf("Payload %q gave %q, %v", payload, got, err)
+		}
+	})
+}
+
+func FuzzExtractSendContent(f *testing.F) {
+	f.Add("application/x-www-form-urlencoded", `d=%5B%22x%22%5D`)
+	f.Add("application/x-www-form-urlencoded; charset=UTF-8", `d=["x"]&d=y`)
+	f.Add("text/plain", `["x"]`)
+	f.Add("text/plain;charset=UTF-8", `"x"`)
+	f.Add("", "abc")
+	f.Add("application/x-www-form-urlencoded", "%zz")
+	f.Fuzz(func(t *testing.T, ctype, body string) {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+		if err != nil {
+			t.Skip()
+		}
+		req.Header.Set("Content-Type", ctype)
+		content, err := extractSendContent(req)
+		if err != nil {
+			if content != "" {
+				t.Fatalf("Error %v came with content %q", err, content)
+			}
+			return
+		}
+		if strings.HasPrefix(strings.ToLower(ctype), "text/plain") && content != body {
+			t.Fatalf("text/plain content was %q, not %q", content, body)
+		}
+		if strings.HasPrefix(strings.ToLower(ctype), "application/x-www-form-urlencoded") {
+			values, _ := url.ParseQuery(body)
+			if content != values.Get("d") {
+				t.Fatalf("Form content was %q, not %q", content, values.Get("d"))
+			}
+		}
+	})
+}
+
+func FuzzMessageMarshalJSON(f *testing.F) {
+	for _, seed := range []string{"", "abc", "\x00\n ￰", "\xff", `"\`, "<&>"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, m string) {
+		js, err := message(m).MarshalJSON()
+		if err != nil {
+			t.Fatalf("MarshalJSON(%q) returned error %v", m, err)
+		}
+		checkEscaped(t, js)
+		var back string
+		if err := json.Unmarshal(js, &back); err != nil {
+			t.Fatalf("Could not decode %q: %v", js, err)
+		}
+		if utf8.ValidString(m) && back != m {
+			t.Fatalf("%q came back as %q", m, back)
+		}
+	})
+}
+
+func FuzzMessageFrame(f *testing.F) {
+	f.Add("abc", "def")
+	f.Add("", "\x00\r\n")
+	f.Add(" ", "\xfe\xff")
+	f.Fuzz(func(t *testing.T, m1, m2 string) {
+		frame := messageFrame(message(m1), message(m2))
+		if !bytes.HasPrefix(frame, []byte("a")) {
+			t.Fatalf("Frame %q does not start with a", frame)
+		}
+		// Frames are sent one per line, so must not contain newlines.
+		checkEscaped(t, frame[1:])
+		var msgs []string
+		if err := json.Unmarshal(frame[1:], &msgs); err != nil || len(msgs) != 2 {
+			t.Fatalf("Could not decode frame %q: %v", frame, err)
+		}
+		if utf8.ValidString(m1) && utf8.ValidString(m2) && (msgs[0] != m1 || msgs[1] != m2) {
+			t.Fatalf("%q and %q came back as %q", m1, m2, msgs)
+		}
+	})
+}
@@ -29,6 +29,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -38,28 +39,91 @@ type connImpl interface {
 	io.ReadWriteCloser
 }
 
+// binaryConn is implemented by connImpls that support binary mode.
+type binaryConn interface {
+	setBinary(binary bool)
+	binary() bool
+}
+
+// protocolConn is implemented by connImpls that can negotiate a websocket
+// sub-protocol.
+type protocolConn interface {
+	protocol() string
+}
+
+// messageConn is implemented by connImpls that can read whole messages.
+type messageConn interface {
+	readMessage() ([]byte, error)
+}
+
 // Conn is a SockJS connection. It is a ReadWriteCloser
 type Conn struct {
 	connImpl
 }
 
+// SetBinary turns binary mode on or off for the connection. In binary mode
+// SockJS sessions base64-encode outbound messages and decode inbound ones, so
+// arbitrary bytes survive the JSON framing; raw websocket connections send
+// binary frames instead of text frames.
+func (c *Conn) SetBinary(binary bool) {
+	if b, ok := c.connImpl.(binaryConn); ok {
+		b.setBinary(binary)
+	}
+}
+
+// Binary reports whether the connection is in binary mode.
+func (c *Conn) Binary() bool {
+	if b, ok := c.connImpl.(binaryConn); ok {
+		return b.binary()
+	}
+	return false
+}
+
+// Protocol returns the websocket sub-protocol negotiated for a raw websocket
+// connection, or "" if there is none.
+func (c *Conn) Protocol() string {
+	if p, ok := c.connImpl.(protocolConn); ok {
+		return p.protocol()
+	}
+	return ""
+}
+
+// ReadMessage reads the next whole message from the connection. If Read has
+// returned only part of a message, ReadMessage returns the rest of it.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	if m, ok := c.connImpl.(messageConn); ok {
+		return m.readMessage()
+	}
+	buf := make([]byte, 65536)
+	n, err := c.Read(buf)
+	return buf[:n], err
+}
+
 // Handler is an interface to a SockJS connection.
 type Handler func(*Conn)
 
 // Router handles all the SockJS requests.
 type Router struct {
-	WebsocketEnabled bool
-	CookieNeeded     bool
-	DisconnectDelay  time.Duration
-	HeartbeatDelay   time.Duration
+	// config is fixed by NewRouter.
+	config Config
 
 	r       *mux.Router
 	handler Handler
 	baseUrl string
 
 	// Sessions
-	sessions    map[string]*session
-	sessionLock sync.RWMutex
+	sessions       map[string]*session
+	nsessions      int
+	clientSessions map[string]int
+	sessionLock    sync.RWMutex
+
+	// Per-client session creation limits
+	clientBuckets map[string]*tokenBucket
+	limitLock     sync.Mutex
+
+	// Stats
+	rotations map[string]uint64
+	statsLock sync.Mutex
 }
 
 func (r *Router) getSession(sessionId string) *session {
@@ -68,27 +132,105 @@ func (r *Router) getSession(sessionId string) *session {
 	return r.sessions[sessionId]
 }
 
-func (r *Router) getOrCreateSession(sessionId string) (s *session, isNew bool) {
+func (r *Router) getOrCreateSession(sessionId string, remote string) (s *session, isNew bool, err error) {
 	r.sessionLock.Lock()
 	defer r.sessionLock.Unlock()
 	s = r.sessions[sessionId]
 	if s == nil {
-		s = newSession(r)
-		s.sessionId = sessionId
+		if err = r.countNewSession(remote); err != nil {
+			return nil, false, err
+		}
+		isNew = true
+		s = newSession(r, sessionId, remote)
 		r.sessions[sessionId] = s
 	}
 	return
 }
 
 func (r *Router) removeSession(sessionId string, s *session) {
-	r.sessionLock.RLock()
-	defer r.sessionLock.RUnlock()
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
 	if s == r.sessions[sessionId] {
 		delete(r.sessions, sessionId)
+		r.uncountSession(s.remote)
+	}
+}
+
+// countSession counts a new session from remote, unless that would exceed
+// the session limits. The caller must hold sessionLock.
+func (r *Router) countSession(remote string) error {
+	if r.config.MaxSessions > 0 && r.nsessions >= r.config.MaxSessions {
+		return TooManySessions
+	}
+	if r.config.MaxSessionsPerClient > 0 && r.clientSessions[remote] >= r.config.MaxSessionsPerClient {
+		return TooManySessions
+	}
+	if r.clientSessions == nil {
+		r.clientSessions = make(map[string]int)
+	}
+	r.nsessions++
+	r.clientSessions[remote]++
+	return nil
+}
+
+// countNewSession counts a new session from remote, unless that would exceed
+// the session limits or its client's SessionRateLimit. A session refused for
+// the limits does not use up the client's rate. The caller must hold
+// sessionLock.
+func (r *Router) countNewSession(remote string) error {
+	if err := r.countSession(remote); err != nil {
+		return err
+	}
+	if !r.allowNewSession(remote) {
+		r.uncountSession(remote)
+		return RateLimited
 	}
+	return nil
+}
+
+// uncountSession undoes countSession. The caller must hold sessionLock.
+func (r *Router) uncountSession(remote string) {
+	r.nsessions--
+	if n := r.clientSessions[remote]; n > 1 {
+		r.clientSessions[remote] = n - 1
+	} else {
+		delete(r.clientSessions, remote)
+	}
+}
+
+// full reports whether a new session from remote would be refused.
+func (r *Router) full(remote string) bool {
+	r.sessionLock.RLock()
+	defer r.sessionLock.RUnlock()
+	if r.config.MaxSessions > 0 && r.nsessions >= r.config.MaxSessions {
+		return true
+	}
+	return r.config.MaxSessionsPerClient > 0 && r.clientSessions[remote] >= r.config.MaxSessionsPerClient
+}
+
+// refusalFrame is the close frame for a session that could not be created.
+func refusalFrame(err error) []byte {
+	if err == RateLimited {
+		return closeFrame(CloseRateLimited, "Too many new sessions")
+	}
+	return closeFrame(CloseServerBusy, "Server busy")
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p := req.URL.Path
+	if p != cleanPath(p) && p != "" {
+		// Don't let the mux redirect.
+		notFoundHandler(w, req)
+		return
+	}
+	if p == r.baseUrl {
+		// The base url is the greeting's too.
+		u := *req.URL
+		u.Path = r.baseUrl + "/"
+		r2 := *req
+		r2.URL = &u
+		req = &r2
+	}
 	r.r.ServeHTTP(w, req)
 }
 
@@ -97,6 +239,22 @@ func writeNoCache(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 }
 
+// writeNoSniff stops browsers from treating responses as anything but
+// their content type.
+func writeNoSniff(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// writeFrameAncestors says which pages may frame the iframe and htmlfile
+// pages.
+func writeFrameAncestors(r *Router, w http.ResponseWriter) {
+	sources := "*"
+	if len(r.config.FrameAncestors) > 0 {
+		sources = strings.Join(r.config.FrameAncestors, " ")
+	}
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+sources)
+}
+
 func writeCacheAndExpires(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
 	exp := time.Now().Add(time.Hour * 24 * 365).UTC().Format(http.TimeFormat)
@@ -136,6 +294,8 @@ func (r *Router) infoMethod(w http.ResponseWriter, req *http.Request) {
 	// cors
 	writeCorsHeaders(w, req)
 
+	writeSessionCookie(r, w, req)
+
 	// Response status
 	if req.Method == "OPTIONS" {
 		writeCacheAndExpires(w, req)
@@ -145,8 +305,20 @@ func (r *Router) infoMethod(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Tell clients to back off if we have no room for them.
+	if r.full(r.remoteHost(req)) {
+		errStatus(w, http.StatusServiceUnavailable)
+		return
+	}
+
 	data := make(map[string]interface{})
-	data["websocket"] = r.WebsocketEnabled
+	data["websocket"] = r.config.WebsocketEnabled
+	if r.config.InfoTransports {
+		data["transports"] = r.config.transports()
+	}
+	if r.config.InfoBaseUrl != "" {
+		data["base_url"] = r.config.InfoBaseUrl
+	}
 	data["cookie_needed"] = false
 	data["origins"] = []string{"*:*"}
 	entropy := make([]byte, 4)
@@ -154,7 +326,7 @@ func (r *Router) infoMethod(w http.ResponseWriter, req *http.Request) {
 	var uent uint32
 	binary.Read(bytes.NewReader(entropy), binary.LittleEndian, &uent)
 	data["entropy"] = uent
-	if r.CookieNeeded {
+	if r.config.CookieNeeded {
 		data["cookie_needed"] = true
 	}
 
@@ -170,10 +342,6 @@ func (r *Router) wrapHandler(f func(r *Router, w http.ResponseWriter, req *http.
 	}
 }
 
-func infoFunc(r *Router) func(w http.ResponseWriter, req *http.Request) {
-	return r.wrapHandler((*Router).infoMethod)
-}
-
 func greetingHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-type", "text/plain; charset=UTF-8")
 	body := "Welcome to SockJS!\n"
@@ -188,64 +356,63 @@ func notFoundHandler(w http.ResponseWriter, req *http.Request) {
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
 
-// NewRouter returns a new gosockjs router listening at baseUrl. baseUrl should
-// be an absolute path.
-func NewRouter(baseUrl string, h Handler) (*Router, error) {
+// NewRouter returns a new gosockjs router listening at baseUrl, which should
+// be an absolute path without a trailing slash. If baseUrl is empty the
+// router's paths are relative, and it can be mounted anywhere by stripping
+// the prefix:
+//
+//	r, _ := gosockjs.NewRouter("", h)
+//	mux.Handle("/echo", http.StripPrefix("/echo", r))
+//	mux.Handle("/echo/", http.StripPrefix("/echo", r))
+//
+// The router starts with DefaultConfig, changed by the options in order. It
+// is an error if the result, or the base url, is not valid. The
+// configuration cannot be changed afterwards.
+func NewRouter(baseUrl string, h Handler, opts ...Option) (*Router, error) {
 	r := new(Router)
 
 	// Properties
-	r.WebsocketEnabled = true
-	r.DisconnectDelay = time.Second * 5
-	r.HeartbeatDelay = time.Second * 25
+	r.config = DefaultConfig()
+	for _, opt := range opts {
+		opt(&r.config)
+	}
+	// The caller's slices may be shared; the router's config is its own.
+	r.config = r.config.clone()
+	if err := validBaseUrl(baseUrl); err != nil {
+		return nil, err
+	}
+	if err := r.config.Validate(); err != nil {
+		return nil, err
+	}
 	r.handler = h
 	r.sessions = make(map[string]*session)
+	r.baseUrl = baseUrl
 
-	// Routing
+	// Routing. Paths are matched exactly; there are no trailing slash
+	// redirects.
 	r.r = mux.NewRouter()
-	r.r.StrictSlash(true)
-	sub := r.r.PathPrefix(baseUrl).Subrouter()
-	sub.StrictSlash(true)
-	ss := sub.PathPrefix("/{serverid:[^./]+}/{sessionid:[^./]+}").Subrouter()
-
-	// Greeting, info
-	r.r.HandleFunc(baseUrl+"/", r.wrapHandler(greetingHandler)).Methods("GET")
-	sub.HandleFunc("/info", infoFunc(r)).Methods("GET", "OPTIONS")
-
-	// Iframe
-	sub.HandleFunc("/iframe.html", r.wrapHandler(iframeHandler)).Methods("GET")
-	sub.HandleFunc("/iframe-.html", r.wrapHandler(iframeHandler)).Methods("GET")
-	sub.HandleFunc("/iframe-{ver}.html", r.wrapHandler(iframeHandler)).Methods("GET")
-
-	// Websockets. We don't worry about sessions.
-	sub.HandleFunc("/websocket", r.wrapHandler(rawWebsocketHandler)).Methods("GET")
-	ss.HandleFunc("/websocket", r.wrapHandler(websocketHandler))
-
-	// XHR
-	ss.HandleFunc("/xhr", r.wrapHandler(xhrHandler)).Methods("POST", "OPTIONS")
-	ss.HandleFunc("/xhr_streaming", r.wrapHandler(xhrStreamingHandler)).Methods("POST", "OPTIONS")
-	ss.HandleFunc("/xhr_send", r.wrapHandler(xhrSendHandler)).Methods("POST", "OPTIONS")
-
-	// JSONP
-	ss.HandleFunc("/jsonp", r.wrapHandler(jsonpHandler)).Methods("GET", "OPTIONS")
-	ss.HandleFunc("/jsonp_send", r.wrapHandler(jsonpSendHandler)).Methods("POST", "OPTIONS")
-
-	// Eventsource
-	ss.HandleFunc("/eventsource", r.wrapHandler(eventsourceHandler)).Methods("GET", "OPTIONS")
-
-	// HTML
-	ss.HandleFunc("/htmlfile", r.wrapHandler(htmlfileHandler)).Methods("GET", "OPTIONS")
+	r.r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	for _, rt := range routes {
+		route := r.r.HandleFunc(baseUrl+rt.path, r.wrapHandler(rt.handlerFunc()))
+		if rt.methods != nil {
+			route.Methods(rt.methods...)
+		}
+	}
 
 	return r, nil
 }
 
 // Install creates and installs a Router into the default http ServeMux. baseUrl should
 // be an absolute path.
-func Install(baseUrl string, h Handler) (*Router, error) {
-	r, err := NewRouter(baseUrl, h)
+func Install(baseUrl string, h Handler, opts ...Option) (*Router, error) {
+	if baseUrl == "" {
+		return nil, fmt.Errorf("Install needs a base url.")
+	}
+	r, err := NewRouter(baseUrl, h, opts...)
 	if err != nil {
 		return nil, err
 	}
 	http.Handle(baseUrl+"/", r)
-	http.HandleFunc(baseUrl, r.wrapHandler(greetingHandler))
+	http.Handle(baseUrl, r)
 	return r, nil
 }
@@ -0,0 +1,461 @@
+package gosockjstest
+
+import (
+	"bufio"
+	"bytes"
+	"code.google.com/p/go.net/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mrlauer/gosockjs"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The transports a Client can use. Each name is also the last segment of the
+// transport's url.
+const (
+	Websocket    = "websocket"
+	XhrPolling   = "xhr"
+	XhrStreaming = "xhr_streaming"
+	Jsonp        = "jsonp"
+	Eventsource  = "eventsource"
+	Htmlfile     = "htmlfile"
+)
+
+// Transports lists every transport a Client can use.
+var Transports = []string{Websocket, XhrPolling, XhrStreaming, Jsonp, Eventsource, Htmlfile}
+
+// DefaultTimeout is how long a new Client waits for a frame.
+const DefaultTimeout = 5 * time.Second
+
+var Timeout error = errors.New("Timed out waiting for a frame.")
+
+// CloseError is returned when a Client reading messages gets a close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("Session closed: %d %s", e.Code, e.Reason)
+}
+
+// The callback name used for jsonp and htmlfile.
+const callback = "_gosockjstest.cb"
+
+type result struct {
+	frame Frame
+	err   error
+}
+
+// Client is a scripted SockJS client for one session over one transport. Like
+// a real client it receives continuously, polling or reopening streams as
+// needed, until the session closes or Close is called.
+type Client struct {
+	Transport string
+	// SessionUrl is the base url followed by the server and session ids.
+	SessionUrl string
+	// Timeout is how long reads wait for a frame. Zero means forever.
+	Timeout time.Duration
+	// HTTPClient makes the requests of the http transports.
+	HTTPClient *http.Client
+
+	frames  chan result
+	pending []string
+	ws      *websocket.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Dial opens a session with random ids at the SockJS service at baseUrl.
+func Dial(baseUrl, transport string) (*Client, error) {
+	return DialSession(baseUrl, transport, randomServer(), randomId())
+}
+
+// DialSession opens the session with the given ids.
+func DialSession(baseUrl, transport, serverId, sessionId string) (*Client, error) {
+	c := &Client{
+		Transport:  transport,
+		SessionUrl: baseUrl + "/" + serverId + "/" + sessionId,
+		Timeout:    DefaultTimeout,
+		HTTPClient: new(http.Client),
+		frames:     make(chan result),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	switch transport {
+	case Websocket:
+		wsurl := "ws" + strings.TrimPrefix(c.SessionUrl, "http") + "/websocket"
+		ws, err := websocket.Dial(wsurl, "", baseUrl)
+		if err != nil {
+			return nil, err
+		}
+		c.ws = ws
+		go c.readWebsocket()
+	case XhrPolling, XhrStreaming:
+		go c.readHttp("POST", "", readXhrFrame)
+	case Eventsource:
+		go c.readHttp("GET", "", readEventsourceFrame)
+	case Jsonp:
+		go c.readHttp("GET", "?c="+callback, readJsonpFrame)
+	case Htmlfile:
+		go c.readHttp("GET", "?c="+callback, readHtmlfileFrame)
+	default:
+		return nil, errors.New("Unknown transport " + transport)
+	}
+	return c, nil
+}
+
+// ids has its own seeded source, so clients in different test processes don't
+// collide, without touching the global one.
+var ids = struct {
+	rand *rand.Rand
+	lock sync.Mutex
+}{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randomServer() string {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	return fmt.Sprintf("%03d", ids.rand.Intn(1000))
+}
+
+func randomId() string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = chars[ids.rand.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// deliver parses a frame and passes it to the reader. It returns false if
+// the client should stop receiving.
+func (c *Client) deliver(data []byte, err error) bool {
+	var f Frame
+	if err == nil {
+		f, err = ParseFrame(data)
+	}
+	select {
+	case c.frames <- result{f, err}:
+	case <-c.ctx.Done():
+		return false
+	}
+	return err == nil && f.Type != 'c'
+}
+
+func (c *Client) readWebsocket() {
+	defer close(c.frames)
+	for {
+		var m string
+		err := websocket.Message.Receive(c.ws, &m)
+		if c.ctx.Err() != nil || err == io.EOF {
+			return
+		}
+		if !c.deliver([]byte(m), err) {
+			return
+		}
+	}
+}
+
+// readHttp makes requests to the transport's url over and over, reading
+// frames from each response with next until it ends.
+func (c *Client) readHttp(method, query string, next func(*bufio.Reader) ([]byte, error)) {
+	defer close(c.frames)
+	for {
+		req, err := http.NewRequest(method, c.SessionUrl+"/"+c.Transport+query, nil)
+		if err != nil {
+			c.deliver(nil, err)
+			return
+		}
+		resp, err := c.HTTPClient.Do(req.WithContext(c.ctx))
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err == nil && resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("%s returned %s: %s", c.Transport, resp.Status, b)
+		}
+		if err != nil {
+			c.deliver(nil, err)
+			return
+		}
+		br := bufio.NewReader(resp.Body)
+		for {
+			frame, err := next(br)
+			if c.ctx.Err() != nil {
+				resp.Body.Close()
+				return
+			}
+			if err == io.EOF {
+				break
+			}
+			if !c.deliver(frame, err) {
+				resp.Body.Close()
+				return
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// xhr and xhr_streaming send a frame per line, after the streaming prelude.
+func readXhrFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 1 && strings.Trim(line, "h") == "" {
+			// The prelude
+			continue
+		}
+		return []byte(line), nil
+	}
+}
+
+// eventsource sends a frame in the data of each event.
+func readEventsourceFrame(r *bufio.Reader) ([]byte, error) {
+	var data []string
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			if data != nil {
+				return []byte(strings.Join(data, "\n")), nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(line[5:], " "))
+		}
+	}
+}
+
+// jsonp sends a frame as a string passed to the callback.
+func readJsonpFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line != "" {
+			return unwrapCall(line, callback)
+		}
+	}
+}
+
+// htmlfile sends a frame as a string passed to p, in a script tag.
+func readHtmlfileFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "p(") {
+			return unwrapCall(line, "p")
+		}
+	}
+}
+
+func unwrapCall(line, fn string) ([]byte, error) {
+	if !strings.HasPrefix(line, fn+"(") || !strings.HasSuffix(line, ");") {
+		return nil, fmt.Errorf("Expected a call to %s, got %q", fn, line)
+	}
+	var frame string
+	err := json.Unmarshal([]byte(line[len(fn)+1:len(line)-2]), &frame)
+	return []byte(frame), err
+}
+
+// ReadFrame returns the next frame the client receives. It returns io.EOF
+// after the session has closed and every frame has been read.
+func (c *Client) ReadFrame() (Frame, error) {
+	var timer <-chan time.Time
+	if c.Timeout > 0 {
+		timer = time.After(c.Timeout)
+	}
+	select {
+	case r, ok := <-c.frames:
+		if !ok {
+			return Frame{}, io.EOF
+		}
+		return r.frame, r.err
+	case <-timer:
+		return Frame{}, Timeout
+	}
+}
+
+// ReadMessage returns the next message the client receives, skipping open
+// and heartbeat frames. A close frame gives a *CloseError.
+func (c *Client) ReadMessage() (string, error) {
+	for len(c.pending) == 0 {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return "", err
+		}
+		switch f.Type {
+		case 'a':
+			c.pending = f.Messages
+		case 'c':
+			return "", &CloseError{f.Code, f.Reason}
+		}
+	}
+	m := c.pending[0]
+	c.pending = c.pending[1:]
+	return m, nil
+}
+
+// ExpectOpen checks that the next frame is an open frame.
+func (c *Client) ExpectOpen() error {
+	f, err := c.ReadFrame()
+	if err != nil {
+		return err
+	}
+	if f.Type != 'o' {
+		return fmt.Errorf("Expected open frame, got %v", f)
+	}
+	return nil
+}
+
+// ExpectMessages checks that the next messages are msgs.
+func (c *Client) ExpectMessages(msgs ...string) error {
+	var got []string
+	for len(got) < len(msgs) {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("Expected messages %q, got %q then %v", msgs, got, err)
+		}
+		got = append(got, m)
+	}
+	if !reflect.DeepEqual(got, msgs) {
+		return fmt.Errorf("Expected messages %q, got %q", msgs, got)
+	}
+	return nil
+}
+
+// ExpectClose checks that the session closes with the code, with no
+// messages before it.
+func (c *Client) ExpectClose(code int) error {
+	if len(c.pending) > 0 {
+		return fmt.Errorf("Expected close, got messages %q", c.pending)
+	}
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return err
+		}
+		switch f.Type {
+		case 'h':
+			continue
+		case 'c':
+			if f.Code != code {
+				return fmt.Errorf("Expected close code %d, got %v", code, f)
+			}
+			return nil
+		}
+		return fmt.Errorf("Expected close, got %v", f)
+	}
+}
+
+// Send sends messages to the server.
+func (c *Client) Send(msgs ...string) error {
+	if msgs == nil {
+		msgs = []string{}
+	}
+	js, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	if c.ws != nil {
+		return websocket.Message.Send(c.ws, string(js))
+	}
+	var resp *http.Response
+	if c.Transport == Jsonp {
+		form := url.Values{"d": {string(js)}}
+		resp, err = c.HTTPClient.PostForm(c.SessionUrl+"/jsonp_send", form)
+	} else {
+		resp, err = c.HTTPClient.Post(c.SessionUrl+"/xhr_send", "text/plain", bytes.NewReader(js))
+	}
+	if err != nil {
+		return err
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Send returned %s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+// Close stops the client without sending a close frame, like a browser
+// going away. It closes a websocket, or aborts the receiving request in
+// flight of an http transport; either way the server sees the connection
+// drop and closes the session at once. An eventsource session of a Router
+// with EventsourceRetry set instead waits for the client to reconnect, and
+// a polling client closed between polls leaves its session to time out
+// after the Router's DisconnectDelay.
+func (c *Client) Close() error {
+	c.cancel()
+	if c.ws != nil {
+		return c.ws.Close()
+	}
+	return nil
+}
+
+// Conn returns the client as a gosockjs.MessageConn, so client code written
+// against that, such as an RPCConn, can run over it. Reads wait as long as
+// they need to, and end with io.EOF when the session closes; each Write
+// sends one message.
+func (c *Client) Conn() gosockjs.MessageConn {
+	return clientConn{c}
+}
+
+type clientConn struct {
+	c *Client
+}
+
+func (cc clientConn) ReadMessage() ([]byte, error) {
+	for {
+		m, err := cc.c.ReadMessage()
+		switch err.(type) {
+		case nil:
+			return []byte(m), nil
+		case *CloseError:
+			return nil, io.EOF
+		}
+		if err != Timeout {
+			return nil, err
+		}
+	}
+}
+
+func (cc clientConn) Write(data []byte) (int, error) {
+	if err := cc.c.Send(string(data)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (cc clientConn) Close() error {
+	return cc.c.Close()
+}
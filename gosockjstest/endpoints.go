@@ -0,0 +1,76 @@
+package gosockjstest
+
+import (
+	"github.com/mrlauer/gosockjs"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handlers for the endpoints of the sockjs-protocol test server, besides
+// the trivial echo and close.
+
+// MaxAmplify is the largest power of 2 the reference /amplify sends.
+const MaxAmplify = 19
+
+// Amplify returns a handler that answers a message n with 2^n bytes, as
+// /amplify does. Anything that is not a number from 0 to max is taken as 1.
+func Amplify(max int) gosockjs.Handler {
+	return func(c *gosockjs.Conn) {
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			e, err := strconv.Atoi(string(m))
+			if err != nil || e < 0 || e > max {
+				e = 1
+			}
+			c.Write([]byte(strings.Repeat("x", 1<<uint(e))))
+		}
+	}
+}
+
+// Broadcaster sends every message it gets to all of its connections, as
+// /broadcast does. Its Handle method is the handler.
+type Broadcaster struct {
+	conns map[*gosockjs.Conn]bool
+	lock  sync.Mutex
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{conns: make(map[*gosockjs.Conn]bool)}
+}
+
+func (b *Broadcaster) Handle(c *gosockjs.Conn) {
+	b.lock.Lock()
+	b.conns[c] = true
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		delete(b.conns, c)
+		b.lock.Unlock()
+	}()
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		// Write without the lock, so one slow connection does not hold up
+		// the others joining and leaving.
+		for _, conn := range b.members() {
+			conn.Write(m)
+		}
+	}
+}
+
+// members returns the current connections.
+func (b *Broadcaster) members() []*gosockjs.Conn {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	conns := make([]*gosockjs.Conn, 0, len(b.conns))
+	for conn := range b.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
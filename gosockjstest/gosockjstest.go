@@ -0,0 +1,111 @@
+/*
+Package gosockjstest provides utilities for testing applications built on
+gosockjs without a browser.
+
+It runs a gosockjs Router on an httptest.Server, and provides scripted SockJS
+clients for each transport that can send messages and check the frames they
+receive:
+
+	func TestEcho(t *testing.T) {
+		server := gosockjstest.NewServer("/echo", echo)
+		defer server.Close()
+		for _, transport := range gosockjstest.Transports {
+			c, err := gosockjstest.Dial(server.BaseUrl, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.ExpectOpen(); err != nil {
+				t.Fatal(err)
+			}
+			c.Send("hello")
+			if err := c.ExpectMessages("hello"); err != nil {
+				t.Error(transport, err)
+			}
+			c.Close()
+		}
+	}
+*/
+package gosockjstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mrlauer/gosockjs"
+	"net/http/httptest"
+)
+
+// Server is a gosockjs Router running on an httptest.Server.
+type Server struct {
+	*httptest.Server
+	Router *gosockjs.Router
+	// BaseUrl is the full url of the SockJS service.
+	BaseUrl string
+}
+
+// NewServer starts a Router for h at the path prefix, configured with the
+// options. The caller should call Close when done.
+func NewServer(prefix string, h gosockjs.Handler, opts ...gosockjs.Option) *Server {
+	r, err := gosockjs.NewRouter(prefix, h, opts...)
+	if err != nil {
+		panic(err)
+	}
+	s := httptest.NewServer(r)
+	return &Server{Server: s, Router: r, BaseUrl: s.URL + prefix}
+}
+
+// Frame is a SockJS frame, as received by a client.
+type Frame struct {
+	// Type is one of 'o' (open), 'h' (heartbeat), 'a' (messages) and 'c' (close).
+	Type byte
+	// Messages are the messages in an 'a' frame.
+	Messages []string
+	// Code and Reason are the contents of a 'c' frame.
+	Code   int
+	Reason string
+}
+
+// ParseFrame decodes a SockJS frame.
+func ParseFrame(data []byte) (Frame, error) {
+	if len(data) == 0 {
+		return Frame{}, errors.New("Empty frame")
+	}
+	f := Frame{Type: data[0]}
+	body := data[1:]
+	switch f.Type {
+	case 'o', 'h':
+		if len(body) != 0 {
+			return f, fmt.Errorf("Unexpected data in frame %q", data)
+		}
+	case 'a':
+		if err := json.Unmarshal(body, &f.Messages); err != nil {
+			return f, fmt.Errorf("Bad message frame %q: %v", data, err)
+		}
+	case 'c':
+		var c []interface{}
+		if err := json.Unmarshal(body, &c); err != nil || len(c) != 2 {
+			return f, fmt.Errorf("Bad close frame %q", data)
+		}
+		code, ok1 := c[0].(float64)
+		reason, ok2 := c[1].(string)
+		if !ok1 || !ok2 {
+			return f, fmt.Errorf("Bad close frame %q", data)
+		}
+		f.Code, f.Reason = int(code), reason
+	default:
+		return f, fmt.Errorf("Unknown frame %q", data)
+	}
+	return f, nil
+}
+
+func (f Frame) String() string {
+	switch f.Type {
+	case 'a':
+		js, _ := json.Marshal(f.Messages)
+		return "a" + string(js)
+	case 'c':
+		js, _ := json.Marshal([]interface{}{f.Code, f.Reason})
+		return "c" + string(js)
+	}
+	return string(f.Type)
+}
@@ -0,0 +1,71 @@
+package gosockjstest
+
+import (
+	"github.com/mrlauer/gosockjs"
+	"io"
+	"testing"
+)
+
+func echo(c *gosockjs.Conn) {
+	io.Copy(c, c)
+}
+
+func TestParseFrame(t *testing.T) {
+	good := []string{"o", "h", `a["a","b"]`, `c[3000,"Go away!"]`}
+	for _, s := range good {
+		f, err := ParseFrame([]byte(s))
+		if err != nil || f.String() != s {
+			t.Errorf("%s parsed as %v with error %v", s, f, err)
+		}
+	}
+	bad := []string{"", "x", "oo", "a[1]", `a"a"`, `c[3000]`, `c["a","b"]`}
+	for _, s := range bad {
+		if f, err := ParseFrame([]byte(s)); err == nil {
+			t.Errorf("%q parsed as %v", s, f)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	server := NewServer("/echo", echo)
+	defer server.Close()
+	for _, transport := range Transports {
+		c, err := Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Errorf("%s: %v", transport, err)
+			continue
+		}
+		if err := c.ExpectOpen(); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		msgs := []string{"abc", "  \x00 <script>", `"quoted"`}
+		if err := c.Send(msgs...); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		if err := c.ExpectMessages(msgs...); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Close()
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := NewServer("/close", func(c *gosockjs.Conn) {
+		c.Close()
+	})
+	defer server.Close()
+	for _, transport := range Transports {
+		c, err := Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Errorf("%s: %v", transport, err)
+			continue
+		}
+		if err := c.ExpectOpen(); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		if err := c.ExpectClose(3000); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Close()
+	}
+}
@@ -4,17 +4,27 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // Hijack an http connection to keep it open til the client closes it.
 
 // ChunkedWriter writes in the format acceptable for chunked transfer encoding.
 type chunkedWriter struct {
-	w      io.Writer
-	closed bool
-	lock   sync.Mutex
+	w io.Writer
+	// timeout, if positive, is the deadline for each write to a net.Conn.
+	timeout time.Duration
+	closed  bool
+	lock    sync.Mutex
+}
+
+func (w *chunkedWriter) setDeadline() {
+	if c, ok := w.w.(net.Conn); ok && w.timeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
 }
 
 func (w *chunkedWriter) Write(data []byte) (int, error) {
@@ -27,8 +37,11 @@ func (w *chunkedWriter) Write(data []byte) (int, error) {
 	if n == 0 {
 		return 0, nil
 	}
+	w.setDeadline()
 	nwritten, err := fmt.Fprintf(w.w, "%x\r\n%s\r\n", n, data)
 	if err != nil {
+		// Whatever went wrong, the rest of the stream would be garbled.
+		w.closed = true
 		// A reasonable guess as to how much was written
 		nheader := len(fmt.Sprintf("%x\r\n", n))
 		ntoreturn := nwritten - nheader
@@ -37,7 +50,7 @@ func (w *chunkedWriter) Write(data []byte) (int, error) {
 		} else if ntoreturn > n {
 			ntoreturn = n
 		}
-		return nwritten, err
+		return ntoreturn, err
 	}
 	return n, nil
 }
@@ -50,6 +63,7 @@ func (w *chunkedWriter) end() {
 		return
 	}
 	w.closed = true
+	w.setDeadline()
 	fmt.Fprint(w.w, "0\r\n\r\n")
 }
 
@@ -65,11 +79,17 @@ func (w *chunkedWriter) Close() error {
 // Hijack a connection, passing control to the given function.
 // This should happen after writing headers, and the content type should be set!
 // If the client closes the connection the function's argument will be closed.
-func hijackAndContinue(w http.ResponseWriter, handler func(conn io.WriteCloser, done chan struct{})) error {
+// Writes to it fail if they take longer than timeout, unless that is zero.
+func hijackAndContinue(w http.ResponseWriter, timeout time.Duration, handler func(conn io.WriteCloser, done chan struct{})) error {
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
 		return errors.New("ResponseWriter not a hijacker")
 	}
+	// Anything written so far, like a prelude, may still be buffered in the
+	// ResponseWriter, which would drop it on Hijack.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
 
 	conn, rwc, err := hijacker.Hijack()
 	if err != nil {
@@ -88,6 +108,6 @@ func hijackAndContinue(w http.ResponseWriter, handler func(conn io.WriteCloser,
 			}
 		}
 	}()
-	go handler(&chunkedWriter{w: conn}, done)
+	go handler(&chunkedWriter{w: conn, timeout: timeout}, done)
 	return nil
 }
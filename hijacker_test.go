@@ -37,7 +37,7 @@ func simpleTestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Transfer-encoding", "chunked")
 	w.Header().Set("Content-type", "text/plain")
 	w.WriteHeader(200)
-	hijackAndContinue(w, simpleTestHijacker)
+	hijackAndContinue(w, 0, simpleTestHijacker)
 }
 
 func TestHijackerSimple(t *testing.T) {
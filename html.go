@@ -23,6 +23,7 @@ var _htmlFile string = `<!doctype html>
 `
 
 type htmlfileOptions struct {
+	streamLimit
 	callback string
 }
 
@@ -39,10 +40,6 @@ func (o htmlfileOptions) contentType() string {
 	return "text/html; charset=UTF-8"
 }
 
-func (o htmlfileOptions) maxBytes() int {
-	return 4096
-}
-
 func (o htmlfileOptions) writePrelude(w io.Writer) error {
 	prelude := fmt.Sprintf(_htmlFile, o.callback)
 	// It must be at least 1024 bytes.
@@ -59,15 +56,14 @@ func (o htmlfileOptions) streaming() bool {
 }
 
 func htmlfileHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	err := req.ParseForm()
-	if err != nil {
-		http.Error(w, "Bad query", http.StatusInternalServerError)
+	callback, ok := callbackParam(w, req)
+	if !ok {
 		return
 	}
-	callback := req.Form.Get("c")
-	if callback == "" {
-		http.Error(w, `"callback" parameter required`, http.StatusInternalServerError)
-		return
+	writeFrameAncestors(r, w)
+	opts := htmlfileOptions{
+		streamLimit: streamLimit{"htmlfile", r.config.HtmlfileLimit},
+		callback:    callback,
 	}
-	xhrHandlerBase(htmlfileOptions{callback: callback}, r, w, req)
+	xhrHandlerBase(opts, r, w, req)
 }
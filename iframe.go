@@ -1,12 +1,58 @@
 package gosockjs
 
 import (
+	"code.google.com/p/gorilla/mux"
 	"crypto/md5"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 )
 
+// The default client scripts.
+const (
+	sockjs03Url       = "http://cdn.sockjs.org/sockjs-0.3.min.js"
+	sockjs03PinnedFmt = "http://cdn.sockjs.org/sockjs-%s.min.js"
+	sockjs10PinnedFmt = "https://cdn.jsdelivr.net/npm/sockjs-client@%s/dist/sockjs.min.js"
+)
+
+// releaseVersion matches client versions that can be pinned.
+var releaseVersion = regexp.MustCompile(`^(\d+)\.\d+\.\d+$`)
+
+// clientMajor returns the major version of a release version, or "" if ver
+// is not one.
+func clientMajor(ver string) string {
+	if m := releaseVersion.FindStringSubmatch(ver); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// sockjsUrl is the client script for an iframe requested by client version
+// ver, which may be empty or junk. The iframe's script talks to the page's
+// client, so it must be of the same generation; a 1.x client refuses an
+// iframe that is not exactly its own version, while 0.3 clients only log the
+// mismatch. Clients of both generations ask for a plain iframe.html, which
+// gets the configured ClientVersion, or the latest 0.3 script.
+func (r *Router) sockjsUrl(ver string) string {
+	if r.config.SockjsUrl != "" {
+		return r.config.SockjsUrl
+	}
+	major := clientMajor(ver)
+	if major == "" {
+		ver = r.config.ClientVersion
+		major = clientMajor(ver)
+	}
+	switch major {
+	case "":
+		return sockjs03Url
+	case "0":
+		return fmt.Sprintf(sockjs03PinnedFmt, ver)
+	default:
+		return fmt.Sprintf(sockjs10PinnedFmt, ver)
+	}
+}
+
 var iframeFmt = `<!DOCTYPE html>
 <html>
 <head>
@@ -25,7 +71,7 @@ var iframeFmt = `<!DOCTYPE html>
 </html>`
 
 func iframeHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	iframe := fmt.Sprintf(iframeFmt, "http://cdn.sockjs.org/sockjs-0.3.min.js")
+	iframe := fmt.Sprintf(iframeFmt, r.sockjsUrl(mux.Vars(req)["ver"]))
 	md5 := md5.New()
 	io.WriteString(md5, iframe)
 	qmd5 := fmt.Sprintf(`"%x"`, md5.Sum(nil))
@@ -36,6 +82,8 @@ func iframeHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.Header().Set("content-type", "text/html; charset=UTF-8")
+	writeNoSniff(w)
+	writeFrameAncestors(r, w)
 	writeCacheAndExpires(w, req)
 
 	w.Header().Set("ETag", qmd5)
@@ -0,0 +1,124 @@
+package gosockjs_test
+
+import (
+	"encoding/json"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIframeClientVersions(t *testing.T) {
+	const (
+		url03  = "http://cdn.sockjs.org/sockjs-0.3.min.js"
+		url034 = "http://cdn.sockjs.org/sockjs-0.3.4.min.js"
+		url161 = "https://cdn.jsdelivr.net/npm/sockjs-client@1.6.1/dist/sockjs.min.js"
+		url115 = "https://cdn.jsdelivr.net/npm/sockjs-client@1.1.5/dist/sockjs.min.js"
+	)
+	tests := []struct {
+		version string
+		path    string
+		script  string
+	}{
+		{"", "/iframe.html", url03},
+		{"", "/iframe-a.html", url03},
+		{"", "/iframe-0.3.4.html", url034},
+		{"", "/iframe-1.6.1.html", url161},
+		{"", "/iframe-1.0.0-beta.12.html", url03},
+		{"0.3.4", "/iframe.html", url034},
+		{"0.3.4", "/iframe-1.6.1.html", url161},
+		{"1.6.1", "/iframe.html", url161},
+		{"1.6.1", "/iframe-.html", url161},
+		{"1.6.1", "/iframe-a.html", url161},
+		{"1.6.1", "/iframe-1.1.5.html", url115},
+		{"1.6.1", "/iframe-0.3.4.html", url034},
+	}
+	for _, test := range tests {
+		server := gosockjstest.NewServer("/echo", echo, func(c *gosockjs.Config) {
+			c.ClientVersion = test.version
+		})
+		r, body := request(t, "GET", server.BaseUrl+test.path, "")
+		expectStatus(t, r, http.StatusOK)
+		if !strings.Contains(body, `<script src="`+test.script+`">`) {
+			t.Errorf("Version %q %s loaded the wrong script:\n%s", test.version, test.path, body)
+		}
+		server.Close()
+	}
+
+	server := gosockjstest.NewServer("/echo", echo, func(c *gosockjs.Config) {
+		c.SockjsUrl = "/static/sockjs.js"
+	})
+	defer server.Close()
+	_, body := request(t, "GET", server.BaseUrl+"/iframe-1.6.1.html", "")
+	if !strings.Contains(body, `<script src="/static/sockjs.js">`) {
+		t.Errorf("SockjsUrl was not used:\n%s", body)
+	}
+}
+
+func TestClientGenerations(t *testing.T) {
+	for _, version := range []string{"0.3.4", "1.6.1"} {
+		server := gosockjstest.NewServer("/echo", echo, func(c *gosockjs.Config) {
+			c.ClientVersion = version
+		})
+
+		// Sessions on every transport.
+		for _, transport := range gosockjstest.Transports {
+			c, err := gosockjstest.Dial(server.BaseUrl, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ExpectOpen()
+			c.Send("hello")
+			if err := c.ExpectMessages("hello"); err != nil {
+				t.Errorf("Version %s %s: %v", version, transport, err)
+			}
+			c.Close()
+		}
+
+		// Sends: 204 for xhr_send, 200 "ok" for jsonp_send, which both
+		// generations accept.
+		u := sessionUrl(server.BaseUrl)
+		r, _ := request(t, "POST", u+"/xhr", "")
+		expectStatus(t, r, http.StatusOK)
+		r, body := request(t, "POST", u+"/xhr_send", `["a"]`)
+		expectStatus(t, r, http.StatusNoContent)
+		expect(t, "xhr_send body", body, "")
+		r, body = request(t, "POST", u+"/jsonp_send", `d=["b"]`, "Content-Type", "application/x-www-form-urlencoded")
+		expectStatus(t, r, http.StatusOK)
+		expect(t, "jsonp_send body", body, "ok")
+		r, body = request(t, "POST", sessionUrl(server.BaseUrl)+"/xhr_send", `["a"]`)
+		expectStatus(t, r, http.StatusNotFound)
+
+		// The raw websocket.
+		ws := dialWebsocket(t, server.BaseUrl+"/websocket")
+		ws.Write([]byte("raw"))
+		expect(t, "raw websocket echo", receive(t, ws), "raw")
+		ws.Close()
+
+		// /info has no base_url unless there is one.
+		_, body = request(t, "GET", server.BaseUrl+"/info", "")
+		var info map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &info); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := info["base_url"]; ok {
+			t.Errorf("Version %s info had a base_url: %s", version, body)
+		}
+		server.Close()
+	}
+
+	server := gosockjstest.NewServer("/echo", echo, func(c *gosockjs.Config) {
+		c.ClientVersion = "1.6.1"
+		c.InfoBaseUrl = "http://node1.example.com/echo"
+	})
+	defer server.Close()
+	_, body := request(t, "GET", server.BaseUrl+"/info", "")
+	var info map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["base_url"] != "http://node1.example.com/echo" {
+		t.Errorf("Info had the wrong base_url: %s", body)
+	}
+}
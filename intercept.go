@@ -0,0 +1,54 @@
+package gosockjs
+
+import (
+	"errors"
+)
+
+// An Interceptor sees a message on its way between a session and its
+// client, and returns the message to pass on, which may be changed. c is the
+// same Conn the session's handler gets. If it returns DropMessage the
+// message is dropped; any other error closes the session with
+// CloseIntercepted and the error as the reason.
+type Interceptor func(c *Conn, m []byte) ([]byte, error)
+
+var DropMessage error = errors.New("Message dropped.")
+
+// CloseIntercepted is the close code for sessions closed by an Interceptor.
+const CloseIntercepted = 3400
+
+// InterceptInbound is an Option that adds an interceptor for messages from
+// clients, which sees each message before the handler can read it. The
+// messages a client sends together are all intercepted before any is queued
+// for the handler. Interceptors run in the order they are added. Raw
+// websocket connections are not intercepted.
+func InterceptInbound(f Interceptor) Option {
+	return func(c *Config) {
+		c.InboundInterceptors = append(c.InboundInterceptors, f)
+	}
+}
+
+// InterceptOutbound is an Option that adds an interceptor for messages to
+// clients, which sees each message the handler writes before it is queued
+// for sending.
+func InterceptOutbound(f Interceptor) Option {
+	return func(c *Config) {
+		c.OutboundInterceptors = append(c.OutboundInterceptors, f)
+	}
+}
+
+// intercept runs a message through a chain of interceptors. A nil message
+// with a nil error means it was dropped.
+func (s *session) intercept(chain []Interceptor, m []byte) ([]byte, error) {
+	for _, f := range chain {
+		var err error
+		m, err = f(s.conn, m)
+		if err == DropMessage {
+			return nil, nil
+		}
+		if err != nil {
+			s.closeWith(CloseIntercepted, err.Error())
+			return nil, err
+		}
+	}
+	return m, nil
+}
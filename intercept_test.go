@@ -0,0 +1,87 @@
+package gosockjs_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	// The handler echoes, and says what it read.
+	type read struct {
+		conn *gosockjs.Conn
+		m    string
+	}
+	reads := make(chan read, 100)
+	intercepted := make(chan *gosockjs.Conn, 100)
+	inbound1 := gosockjs.InterceptInbound(func(c *gosockjs.Conn, m []byte) ([]byte, error) {
+		intercepted <- c
+		switch string(m) {
+		case "drop":
+			return nil, gosockjs.DropMessage
+		case "bad":
+			return nil, errors.New("Bad message")
+		}
+		return bytes.Replace(m, []byte("darn"), []byte("****"), -1), nil
+	})
+	inbound2 := gosockjs.InterceptInbound(func(c *gosockjs.Conn, m []byte) ([]byte, error) {
+		return append([]byte("in:"), m...), nil
+	})
+	outbound := gosockjs.InterceptOutbound(func(c *gosockjs.Conn, m []byte) ([]byte, error) {
+		if bytes.HasSuffix(m, []byte("secret")) {
+			return nil, gosockjs.DropMessage
+		}
+		return append([]byte("out:"), m...), nil
+	})
+	server := gosockjstest.NewServer("/intercept", func(c *gosockjs.Conn) {
+		defer func() { reads <- read{c, ""} }()
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			reads <- read{c, string(m)}
+			c.Write(m)
+		}
+	}, inbound1, inbound2, outbound)
+	defer server.Close()
+
+	for _, transport := range gosockjstest.Transports {
+		c, err := gosockjstest.Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		c.Send("hello", "drop", "oh darn", "secret", "bye")
+		if err := c.ExpectMessages("out:in:hello", "out:in:oh ****", "out:in:bye"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		// A batch with a bad message is not delivered at all.
+		c.Send("first", "bad")
+		if err := c.ExpectClose(gosockjs.CloseIntercepted); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Close()
+
+		var conn *gosockjs.Conn
+		for rd := range reads {
+			if conn == nil {
+				conn = rd.conn
+			}
+			if rd.m == "" {
+				break
+			}
+			if rd.m == "in:first" {
+				t.Errorf("%s: part of a bad batch was delivered", transport)
+			}
+		}
+		for len(intercepted) > 0 {
+			if ic := <-intercepted; ic != conn {
+				t.Errorf("%s: interceptor got Conn %p, handler %p", transport, ic, conn)
+				break
+			}
+		}
+	}
+}
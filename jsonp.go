@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"regexp"
 )
 
 type jsonpOptions struct {
@@ -43,7 +45,8 @@ func (o jsonpOptions) streaming() bool {
 
 func extractSendContent(req *http.Request) (string, error) {
 	// What are the options? Is this it?
-	ctype := req.Header.Get("Content-Type")
+	// Parameters, like a charset, don't matter.
+	ctype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
 	buf := bytes.NewBuffer(nil)
 	io.Copy(buf, req.Body)
 	req.Body.Close()
@@ -72,7 +75,7 @@ func jsonpSendHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 		http.NotFoundHandler().ServeHTTP(w, req)
 		return
 	}
-	xhrJsessionid(r, w, req)
+	writeSessionCookie(r, w, req)
 
 	payload, err := extractSendContent(req)
 	if err != nil {
@@ -85,21 +88,41 @@ func jsonpSendHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 	}
 	err = s.fromClient(message(payload))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), sendErrorStatus(err))
 		return
 	}
 	io.WriteString(w, "ok")
 }
 
-func jsonpHandler(r *Router, w http.ResponseWriter, req *http.Request) {
+// callbackRe is the grammar of callback names, which are written into
+// scripts: dotted javascript identifiers, like the clients' "_jp.a1b2c3".
+var callbackRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+const maxCallbackLength = 256
+
+// callbackParam returns the callback of a jsonp or htmlfile request. If it
+// is missing or invalid it writes an error and returns false.
+func callbackParam(w http.ResponseWriter, req *http.Request) (string, bool) {
 	err := req.ParseForm()
 	if err != nil {
 		http.Error(w, "Bad query", http.StatusInternalServerError)
-		return
+		return "", false
 	}
 	callback := req.Form.Get("c")
 	if callback == "" {
 		http.Error(w, `"callback" parameter required`, http.StatusInternalServerError)
+		return "", false
+	}
+	if len(callback) > maxCallbackLength || !callbackRe.MatchString(callback) {
+		http.Error(w, `invalid "callback" parameter`, http.StatusInternalServerError)
+		return "", false
+	}
+	return callback, true
+}
+
+func jsonpHandler(r *Router, w http.ResponseWriter, req *http.Request) {
+	callback, ok := callbackParam(w, req)
+	if !ok {
 		return
 	}
 	xhrHandlerBase(jsonpOptions{callback}, r, w, req)
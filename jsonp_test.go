@@ -0,0 +1,89 @@
+package gosockjs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallbackValidation(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.FrameAncestors = []string{"'self'", "https://example.com"}
+	})
+	defer server.Close()
+
+	bad := []string{
+		"alert(1)//",
+		"a;alert(1)",
+		"</script><script>alert(1)</script>",
+		"x\nalert(1)",
+		"a b",
+		"a..b",
+		"a.",
+		".a",
+		"1abc",
+		"a-b",
+		"abc(",
+		"*",
+		" ",
+		strings.Repeat("a", 300),
+	}
+	for _, transport := range []string{"jsonp", "htmlfile"} {
+		for _, callback := range bad {
+			u := baseUrl + "/000/bad/" + transport + "?c=" + url.QueryEscape(callback)
+			r, err := http.Get(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := bodyString(r)
+			if r.StatusCode != http.StatusInternalServerError || !strings.Contains(body, `invalid "callback" parameter`) {
+				t.Errorf("%s callback %q gave %d %q", transport, callback, r.StatusCode, body)
+			}
+		}
+		r, err := http.Get(baseUrl + "/000/bad/" + transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := bodyString(r); r.StatusCode != http.StatusInternalServerError || !strings.Contains(body, `"callback" parameter required`) {
+			t.Errorf("%s without a callback gave %d %q", transport, r.StatusCode, body)
+		}
+	}
+
+	// The clients' callbacks work, with hardening headers.
+	for i, callback := range []string{"callback", "_jp.a1b2c3", "$cb._x.y9"} {
+		for _, transport := range []string{"jsonp", "htmlfile"} {
+			u := fmt.Sprintf("%s/000/%s%d/%s?c=%s", baseUrl, transport, i, transport, url.QueryEscape(callback))
+			req, _ := http.NewRequest("GET", u, nil)
+			req.Close = true
+			r, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transport == "jsonp" {
+				if body, _ := bodyString(r); body != callback+"(\"o\");\r\n" {
+					t.Errorf("jsonp callback %q gave %q", callback, body)
+				}
+			} else {
+				r.Body.Close()
+			}
+			if r.StatusCode != http.StatusOK || r.Header.Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("%s callback %q gave %d %v", transport, callback, r.StatusCode, r.Header)
+			}
+			csp := r.Header.Get("Content-Security-Policy")
+			if transport == "htmlfile" && csp != "frame-ancestors 'self' https://example.com" {
+				t.Errorf("htmlfile had Content-Security-Policy %q", csp)
+			}
+		}
+	}
+
+	r, err := http.Get(baseUrl + "/iframe.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.Header.Get("Content-Security-Policy") != "frame-ancestors 'self' https://example.com" || r.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("iframe had headers %v", r.Header)
+	}
+}
@@ -0,0 +1,228 @@
+package gosockjs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// NewJSONRPCCodec returns a net/rpc ServerCodec that speaks JSON-RPC 2.0 over
+// c, one request, response or batch per message. Method names are those of
+// net/rpc, "Service.Method". Params may be an object, which is decoded into
+// the method's argument, or an array; a one-element array is decoded as its
+// element. Requests without an id are notifications, and get no response.
+func NewJSONRPCCodec(c MessageConn) rpc.ServerCodec {
+	return &jsonrpcCodec{conn: c, pending: make(map[uint64]*jsonrpcRequest)}
+}
+
+// ServeJSONRPC serves c with a net/rpc server, or rpc.DefaultServer if it is
+// nil, until the connection closes. A handler can be as simple as
+//
+//	func handler(c *gosockjs.Conn) {
+//		gosockjs.ServeJSONRPC(c, nil)
+//	}
+func ServeJSONRPC(c MessageConn, server *rpc.Server) {
+	if server == nil {
+		server = rpc.DefaultServer
+	}
+	server.ServeCodec(NewJSONRPCCodec(c))
+}
+
+type jsonrpcCodec struct {
+	conn MessageConn
+
+	// Requests read but not yet handed to the server, and the params of the
+	// one being read.
+	queue  []*jsonrpcRequest
+	params json.RawMessage
+
+	// Requests the server is working on, by seq.
+	lock    sync.Mutex
+	seq     uint64
+	pending map[uint64]*jsonrpcRequest
+}
+
+type jsonrpcRequest struct {
+	method string
+	params json.RawMessage
+	id     json.RawMessage
+	// notification is true if there is no id.
+	notification bool
+	batch        *jsonrpcBatch
+}
+
+// jsonrpcBatch collects the responses to a batch, which are sent together.
+type jsonrpcBatch struct {
+	remaining int
+	responses []*jsonrpcResponse
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// ReadRequestBody prefixes errors decoding params with this, so that
+// WriteResponse can give them the right code.
+const invalidParamsPrefix = "Invalid params: "
+
+func (c *jsonrpcCodec) ReadRequestHeader(r *rpc.Request) error {
+	for len(c.queue) == 0 {
+		data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.parse(data)
+	}
+	req := c.queue[0]
+	c.queue = c.queue[1:]
+	c.lock.Lock()
+	c.seq++
+	c.pending[c.seq] = req
+	r.Seq = c.seq
+	c.lock.Unlock()
+	r.ServiceMethod = req.method
+	c.params = req.params
+	return nil
+}
+
+// parse queues the requests in a message, answering any that are invalid.
+func (c *jsonrpcCodec) parse(data []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		c.parseOne(data, nil)
+		return
+	}
+	var elts []json.RawMessage
+	if err := json.Unmarshal(data, &elts); err != nil {
+		c.respond(&jsonrpcRequest{id: jsonNull}, nil, &RPCError{Code: RPCParseError, Message: "Parse error"})
+		return
+	}
+	if len(elts) == 0 {
+		c.respond(&jsonrpcRequest{id: jsonNull}, nil, &RPCError{Code: RPCInvalidRequest, Message: "Empty batch"})
+		return
+	}
+	b := &jsonrpcBatch{remaining: len(elts)}
+	for _, elt := range elts {
+		c.parseOne(elt, b)
+	}
+}
+
+func (c *jsonrpcCodec) parseOne(data []byte, b *jsonrpcBatch) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		invalid := &RPCError{Code: RPCInvalidRequest, Message: "Invalid request"}
+		if b == nil && !json.Valid(data) {
+			invalid = &RPCError{Code: RPCParseError, Message: "Parse error"}
+		}
+		c.respond(&jsonrpcRequest{id: jsonNull, batch: b}, nil, invalid)
+		return
+	}
+	req := &jsonrpcRequest{params: fields["params"], batch: b}
+	req.id, req.notification = fields["id"], fields["id"] == nil
+	if req.notification {
+		req.id = jsonNull
+	}
+	var version string
+	json.Unmarshal(fields["jsonrpc"], &version)
+	if version != "2.0" || json.Unmarshal(fields["method"], &req.method) != nil || req.method == "" {
+		// Invalid requests are always answered.
+		req.notification = false
+		c.respond(req, nil, &RPCError{Code: RPCInvalidRequest, Message: "Invalid request"})
+		return
+	}
+	c.queue = append(c.queue, req)
+}
+
+func (c *jsonrpcCodec) ReadRequestBody(x interface{}) error {
+	params := c.params
+	c.params = nil
+	if x == nil || params == nil {
+		return nil
+	}
+	var elts []json.RawMessage
+	if bytes.HasPrefix(params, []byte("[")) && json.Unmarshal(params, &elts) == nil && len(elts) == 1 {
+		params = elts[0]
+	}
+	if err := json.Unmarshal(params, x); err != nil {
+		return errors.New(invalidParamsPrefix + err.Error())
+	}
+	return nil
+}
+
+func (c *jsonrpcCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.lock.Lock()
+	req := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.lock.Unlock()
+	if req == nil {
+		return errors.New("Response to unknown request")
+	}
+	if r.Error != "" {
+		return c.respond(req, nil, rpcErrorFor(r.Error))
+	}
+	return c.respond(req, x, nil)
+}
+
+// rpcErrorFor turns a net/rpc error into an error object.
+func rpcErrorFor(msg string) *RPCError {
+	code := RPCServerError
+	switch {
+	case strings.HasPrefix(msg, invalidParamsPrefix):
+		code = RPCInvalidParams
+	case strings.HasPrefix(msg, "rpc: can't find"), strings.HasPrefix(msg, "rpc: service/method request ill-formed"):
+		code = RPCMethodNotFound
+	}
+	return &RPCError{Code: code, Message: msg}
+}
+
+// respond sends the response to a request, or adds it to its batch.
+func (c *jsonrpcCodec) respond(req *jsonrpcRequest, result interface{}, rpcErr *RPCError) error {
+	resp := &jsonrpcResponse{Version: "2.0", Id: req.id}
+	if rpcErr != nil {
+		resp.Error = rpcErr
+	} else {
+		if result == nil {
+			result = jsonNull
+		}
+		resp.Result = result
+	}
+	if req.batch == nil {
+		if req.notification {
+			return nil
+		}
+		return c.send(resp)
+	}
+	c.lock.Lock()
+	b := req.batch
+	if !req.notification {
+		b.responses = append(b.responses, resp)
+	}
+	b.remaining--
+	done := b.remaining == 0 && len(b.responses) > 0
+	c.lock.Unlock()
+	if done {
+		return c.send(b.responses)
+	}
+	return nil
+}
+
+func (c *jsonrpcCodec) send(v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(js)
+	return err
+}
+
+func (c *jsonrpcCodec) Close() error {
+	return c.conn.Close()
+}
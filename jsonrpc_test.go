@@ -0,0 +1,148 @@
+package gosockjs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type Arith struct {
+	notes chan string
+}
+
+type ArithArgs struct {
+	A, B int
+}
+
+func (a *Arith) Add(args ArithArgs, sum *int) error {
+	*sum = args.A + args.B
+	return nil
+}
+
+func (a *Arith) Divide(args ArithArgs, q *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*q = args.A / args.B
+	return nil
+}
+
+func (a *Arith) Note(note string, _ *struct{}) error {
+	a.notes <- note
+	return nil
+}
+
+// normalizeJSON decodes a message, sorting batches by id so that they can be
+// compared.
+func normalizeJSON(s string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	if batch, ok := v.([]interface{}); ok {
+		id := func(i int) string {
+			m, _ := batch[i].(map[string]interface{})
+			return fmt.Sprint(m["id"])
+		}
+		sort.Slice(batch, func(i, j int) bool { return id(i) < id(j) })
+	}
+	return v, nil
+}
+
+func TestJSONRPC(t *testing.T) {
+	arith := &Arith{notes: make(chan string, 1)}
+	server := rpc.NewServer()
+	server.Register(arith)
+	s := gosockjstest.NewServer("/jsonrpc", func(c *gosockjs.Conn) {
+		gosockjs.ServeJSONRPC(c, server)
+	})
+	defer s.Close()
+
+	c, err := gosockjstest.Dial(s.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectOpen()
+
+	tests := []struct {
+		name, request, response string
+	}{
+		{"by name", `{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":2},"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"by position", `{"jsonrpc":"2.0","method":"Arith.Add","params":[{"A":3,"B":4}],"id":"x"}`,
+			`{"jsonrpc":"2.0","result":7,"id":"x"}`},
+		{"error", `{"jsonrpc":"2.0","method":"Arith.Divide","params":{"A":1,"B":0},"id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"divide by zero"},"id":2}`},
+		{"unknown method", `{"jsonrpc":"2.0","method":"Arith.Multiply","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc: can't find method Arith.Multiply"},"id":3}`},
+		{"bad params", `{"jsonrpc":"2.0","method":"Arith.Add","params":"x","id":4}`, ""},
+		{"parse error", `{"jsonrpc":"2.0","method`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{"invalid request", `{"method":"Arith.Add","id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"},"id":5}`},
+		{"empty batch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Empty batch"},"id":null}`},
+		{"batch", `[
+			{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":1},"id":1},
+			{"jsonrpc":"2.0","method":"Arith.Note","params":["in a batch"]},
+			{"jsonrpc":"2.0","method":"Arith.Divide","params":{"A":9,"B":3},"id":2},
+			1
+		]`, `[
+			{"jsonrpc":"2.0","result":2,"id":1},
+			{"jsonrpc":"2.0","result":3,"id":2},
+			{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"},"id":null}
+		]`},
+	}
+	for _, test := range tests {
+		if err := c.Send(test.request); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.response == "" {
+			// Only the code is predictable.
+			var resp struct {
+				Error gosockjs.RPCError
+			}
+			if json.Unmarshal([]byte(got), &resp); resp.Error.Code != gosockjs.RPCInvalidParams {
+				t.Errorf("%s: got %s", test.name, got)
+			}
+			continue
+		}
+		g, err := normalizeJSON(got)
+		if err != nil {
+			t.Errorf("%s: bad response %q", test.name, got)
+			continue
+		}
+		w, _ := normalizeJSON(test.response)
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.response, got)
+		}
+	}
+	if note := <-arith.notes; note != "in a batch" {
+		t.Errorf("Got note %q", note)
+	}
+
+	// Notifications get no response, even in a batch of them.
+	c.Send(`{"jsonrpc":"2.0","method":"Arith.Note","params":["alone"]}`)
+	if note := <-arith.notes; note != "alone" {
+		t.Errorf("Got note %q", note)
+	}
+	c.Send(`[{"jsonrpc":"2.0","method":"Arith.Note","params":["batched"]}]`)
+	if note := <-arith.notes; note != "batched" {
+		t.Errorf("Got note %q", note)
+	}
+	c.Send(`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":5,"B":5},"id":6}`)
+	if msg, err := c.ReadMessage(); err != nil || msg != `{"jsonrpc":"2.0","result":10,"id":6}` {
+		t.Errorf("After notifications got %q, %v", msg, err)
+	}
+}
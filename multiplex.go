@@ -0,0 +1,164 @@
+package gosockjs
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Multiplexer carries several named channels over one SockJS connection,
+// following the websocket-multiplex convention of the SockJS project. Every
+// message on the connection is "type,topic" or "type,topic,payload": the
+// client opens a channel with sub, sends on it with msg and closes it with
+// uns. The server sends msg and uns the same way.
+//
+// Each channel is served by the Handler registered for its topic, with a
+// Conn of its own. A Multiplexer is used by installing its Serve method:
+//
+//	m := gosockjs.NewMultiplexer()
+//	m.Handle("chat", chat)
+//	m.Handle("ticker", ticker)
+//	gosockjs.Install("/multiplex", m.Serve)
+type Multiplexer struct {
+	handlers map[string]Handler
+	lock     sync.RWMutex
+}
+
+func NewMultiplexer() *Multiplexer {
+	return &Multiplexer{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for channels named topic.
+func (m *Multiplexer) Handle(topic string, h Handler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers[topic] = h
+}
+
+func (m *Multiplexer) handler(topic string) Handler {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.handlers[topic]
+}
+
+// Serve is the Handler for a multiplexed connection. It returns when the
+// connection closes, after closing all of its channels.
+func (m *Multiplexer) Serve(c *Conn) {
+	ms := &muxSession{conn: c, channels: make(map[string]*muxChannel)}
+	defer ms.closeAll()
+	for {
+		data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(string(data), ",", 3)
+		if len(parts) < 2 {
+			// Not ours. Ignore it.
+			continue
+		}
+		kind, topic := parts[0], parts[1]
+		switch kind {
+		case "sub":
+			if ms.channel(topic) != nil {
+				continue
+			}
+			h := m.handler(topic)
+			if h == nil {
+				io.WriteString(c, "uns,"+topic)
+				continue
+			}
+			ch := ms.open(topic)
+			go h(&Conn{ch})
+		case "msg":
+			var payload string
+			if len(parts) == 3 {
+				payload = parts[2]
+			}
+			if ch := ms.channel(topic); ch != nil && !ch.put([]byte(payload)) {
+				// The channel's handler is too far behind.
+				log.Println("Multiplexer: message queue full on", topic)
+				ch.Close()
+			}
+		case "uns":
+			if ch := ms.channel(topic); ch != nil {
+				ms.remove(topic, ch)
+				ch.close()
+			}
+		}
+	}
+}
+
+// muxSession is the state of one multiplexed connection.
+type muxSession struct {
+	conn     *Conn
+	channels map[string]*muxChannel
+	lock     sync.Mutex
+}
+
+func (ms *muxSession) channel(topic string) *muxChannel {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.channels[topic]
+}
+
+func (ms *muxSession) open(topic string) *muxChannel {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ch := &muxChannel{messageQueue: newMessageQueue(maxChannelQueue), ms: ms, topic: topic}
+	ms.channels[topic] = ch
+	return ch
+}
+
+// remove forgets a channel, if it is still the one open for its topic.
+func (ms *muxSession) remove(topic string, ch *muxChannel) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if ms.channels[topic] == ch {
+		delete(ms.channels, topic)
+	}
+}
+
+func (ms *muxSession) closeAll() {
+	ms.lock.Lock()
+	channels := ms.channels
+	ms.channels = make(map[string]*muxChannel)
+	ms.lock.Unlock()
+	for _, ch := range channels {
+		ch.close()
+	}
+}
+
+// The most inbound messages a channel queues for its handler.
+const maxChannelQueue = 1024
+
+// muxChannel is the connImpl of one channel. Inbound messages are queued, so
+// a slow channel does not hold up the others; a channel whose handler falls
+// more than maxChannelQueue behind is closed. Closing the queue closes the
+// channel without telling the client.
+type muxChannel struct {
+	*messageQueue
+	ms    *muxSession
+	topic string
+}
+
+func (ch *muxChannel) Write(data []byte) (int, error) {
+	if ch.isClosed() {
+		return 0, io.EOF
+	}
+	_, err := io.WriteString(ch.ms.conn, "msg,"+ch.topic+","+string(data))
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close closes the channel, telling the client. The connection stays open.
+func (ch *muxChannel) Close() error {
+	if !ch.close() {
+		return nil
+	}
+	ch.ms.remove(ch.topic, ch)
+	_, err := io.WriteString(ch.ms.conn, "uns,"+ch.topic)
+	return err
+}
@@ -0,0 +1,96 @@
+package gosockjs_test
+
+import (
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiplex(t *testing.T) {
+	m := gosockjs.NewMultiplexer()
+	m.Handle("echo", echo)
+	m.Handle("shout", func(c *gosockjs.Conn) {
+		for {
+			data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.Write([]byte(strings.ToUpper(string(data))))
+		}
+	})
+	m.Handle("close", closeSock)
+	server := gosockjstest.NewServer("/multiplex", m.Serve)
+	defer server.Close()
+
+	for _, transport := range gosockjstest.Transports {
+		c, err := gosockjstest.Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		c.Send("sub,echo", "sub,shout")
+		c.Send("msg,echo,a,b", "msg,nobody,x")
+		if err := c.ExpectMessages("msg,echo,a,b"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Send("msg,shout,hello")
+		if err := c.ExpectMessages("msg,shout,HELLO"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		// Unknown channels, and channels the server closes, are unsubscribed.
+		c.Send("sub,nobody")
+		if err := c.ExpectMessages("uns,nobody"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Send("sub,close")
+		if err := c.ExpectMessages("uns,close"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		// Closing one channel leaves the others open.
+		c.Send("uns,echo", "msg,echo,gone", "msg,shout,still here")
+		if err := c.ExpectMessages("msg,shout,STILL HERE"); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Close()
+	}
+}
+
+func TestMultiplexOverflow(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	m := gosockjs.NewMultiplexer()
+	m.Handle("stuck", func(c *gosockjs.Conn) {
+		// A handler that never reads.
+		<-done
+	})
+	m.Handle("echo", echo)
+	server := gosockjstest.NewServer("/multiplex", m.Serve)
+	defer server.Close()
+
+	c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectOpen()
+	c.Send("sub,stuck", "sub,echo")
+	batch := make([]string, 100)
+	for i := range batch {
+		batch[i] = "msg,stuck,x"
+	}
+	// In batches small enough for the session's own queue.
+	for i := 0; i < 11; i++ {
+		c.Send(batch...)
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.ExpectMessages("uns,stuck"); err != nil {
+		t.Errorf("Overflowing a channel: %v", err)
+	}
+	// The other channels carry on.
+	c.Send("msg,echo,a")
+	if err := c.ExpectMessages("msg,echo,a"); err != nil {
+		t.Errorf("After an overflow: %v", err)
+	}
+}
@@ -0,0 +1,851 @@
+package gosockjs_test
+
+// A port of the sockjs-protocol-0.3 test suite, run against Routers set up
+// the way test_server sets them up.
+
+import (
+	"bufio"
+	"code.google.com/p/go.net/websocket"
+	"encoding/json"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The endpoints
+
+func echo(c *gosockjs.Conn) {
+	io.Copy(c, c)
+}
+
+func closeSock(c *gosockjs.Conn) {
+	c.Close()
+}
+
+var installOnce sync.Once
+
+// startProtocolServer installs the endpoints into the default ServeMux, once,
+// and serves them like test_server does.
+func startProtocolServer() *httptest.Server {
+	installOnce.Do(func() {
+		gosockjs.Install("/echo", echo)
+		gosockjs.Install("/close", closeSock)
+		gosockjs.Install("/amplify", gosockjstest.Amplify(gosockjstest.MaxAmplify))
+		gosockjs.Install("/broadcast", gosockjstest.NewBroadcaster().Handle)
+		gosockjs.Install("/disabled_websocket_echo", echo, func(c *gosockjs.Config) {
+			c.WebsocketEnabled = false
+			c.RawWebsocketEnabled = false
+		})
+		gosockjs.Install("/cookie_needed_echo", echo, func(c *gosockjs.Config) {
+			c.CookieNeeded = true
+		})
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Unclean paths are not found, rather than redirected.
+		p := req.URL.Path
+		if clean := path.Clean(p); p != clean && p != clean+"/" {
+			http.NotFound(w, req)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, req)
+	}))
+}
+
+// Utilities
+
+func randomId() string {
+	return strconv.FormatInt(rand.Int63(), 36)
+}
+
+func sessionUrl(base string) string {
+	return base + "/000/" + randomId()
+}
+
+// request makes a request, returning the response with the whole body read.
+func request(t *testing.T, method, u, body string, header ...string) (*http.Response, string) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	// gosockjs closes polling connections, so don't reuse them.
+	req.Close = true
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, u, err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", method, u, err)
+	}
+	return resp, string(b)
+}
+
+// stream starts a request, returning the response with its body unread.
+func stream(t *testing.T, method, u string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest(method, u, nil)
+	req.Close = true
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, u, err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func readN(t *testing.T, r io.Reader, n int) string {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("Reading %d bytes: %v (got %q)", n, err, buf)
+	}
+	return string(buf)
+}
+
+func expect(t *testing.T, what, got, expected string) {
+	if got != expected {
+		t.Errorf("%s was %q, not %q", what, got, expected)
+	}
+}
+
+func expectStatus(t *testing.T, r *http.Response, status int) {
+	if r.StatusCode != status {
+		t.Errorf("%s %s returned %d, not %d", r.Request.Method, r.Request.URL, r.StatusCode, status)
+	}
+}
+
+func verifyNoCache(t *testing.T, r *http.Response) {
+	expect(t, "Cache-Control", r.Header.Get("Cache-Control"), "no-store, no-cache, must-revalidate, max-age=0")
+	if e := r.Header.Get("Expires"); e != "" {
+		t.Errorf("Uncacheable response has Expires %s", e)
+	}
+	if l := r.Header.Get("Last-Modified"); l != "" {
+		t.Errorf("Uncacheable response has Last-Modified %s", l)
+	}
+}
+
+func verifyCors(t *testing.T, r *http.Response, origin string) {
+	if origin == "" || origin == "null" {
+		origin = "*"
+	}
+	expect(t, "Access-Control-Allow-Origin", r.Header.Get("Access-Control-Allow-Origin"), origin)
+	expect(t, "Access-Control-Allow-Credentials", r.Header.Get("Access-Control-Allow-Credentials"), "true")
+}
+
+func verifyNoCookie(t *testing.T, r *http.Response) {
+	if c := r.Header.Get("Set-Cookie"); c != "" {
+		t.Errorf("%s set cookie %s", r.Request.URL, c)
+	}
+}
+
+func verifyContentType(t *testing.T, r *http.Response, ctype string) {
+	expect(t, "Content-Type of "+r.Request.URL.String(), r.Header.Get("Content-Type"), ctype)
+}
+
+func verifyOptions(t *testing.T, u string, methods string) {
+	for _, origin := range []string{"", "test", "null"} {
+		r, body := request(t, "OPTIONS", u, "", "Origin", origin)
+		expectStatus(t, r, http.StatusNoContent)
+		if !strings.Contains(r.Header.Get("Cache-Control"), "max-age=31536000") {
+			t.Errorf("OPTIONS Cache-Control is %s", r.Header.Get("Cache-Control"))
+		}
+		if r.Header.Get("Expires") == "" {
+			t.Errorf("OPTIONS has no Expires")
+		}
+		expect(t, "Access-Control-Max-Age", r.Header.Get("Access-Control-Max-Age"), "31536000")
+		expect(t, "Access-Control-Allow-Methods", r.Header.Get("Access-Control-Allow-Methods"), methods)
+		expect(t, "OPTIONS body", body, "")
+		verifyCors(t, r, origin)
+	}
+}
+
+// Base url greeting
+
+func TestProtocolGreeting(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	for _, u := range []string{"/echo", "/echo/"} {
+		r, body := request(t, "GET", server.URL+u, "")
+		expectStatus(t, r, http.StatusOK)
+		verifyContentType(t, r, "text/plain; charset=UTF-8")
+		expect(t, "Greeting", body, "Welcome to SockJS!\n")
+		verifyNoCookie(t, r)
+	}
+}
+
+func TestProtocolNotFound(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	for _, suffix := range []string{"/a", "/a.html", "//", "///", "/a/a", "/a/a/", "/a", "/a/"} {
+		r, _ := request(t, "GET", server.URL+"/echo"+suffix, "")
+		expectStatus(t, r, http.StatusNotFound)
+	}
+}
+
+// Iframe page
+
+var iframeBody = regexp.MustCompile(`^<!DOCTYPE html>
+<html>
+<head>
+  <meta http-equiv="X-UA-Compatible" content="IE=edge" />
+  <meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+  <script>
+    document.domain = document.domain;
+    _sockjs_onload = function\(\){SockJS.bootstrap_iframe\(\);};
+  </script>
+  <script src="(.*)"></script>
+</head>
+<body>
+  <h2>Don't panic!</h2>
+  <p>This is a SockJS hidden iframe. It's used for cross domain magic.</p>
+</body>
+</html>$`)
+
+func verifyIframe(t *testing.T, u string) {
+	r, body := request(t, "GET", u, "")
+	expectStatus(t, r, http.StatusOK)
+	verifyContentType(t, r, "text/html; charset=UTF-8")
+	expect(t, "Iframe Cache-Control", r.Header.Get("Cache-Control"), "public, max-age=31536000")
+	if r.Header.Get("Expires") == "" {
+		t.Errorf("Iframe has no Expires")
+	}
+	verifyNoCookie(t, r)
+	if r.Header.Get("Last-Modified") != "" {
+		t.Errorf("Iframe has Last-Modified")
+	}
+	if !iframeBody.MatchString(body) {
+		t.Errorf("Iframe body is\n%s", body)
+	}
+}
+
+func TestProtocolIframe(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	base := server.URL + "/echo"
+	good := []string{
+		"/iframe.html",
+		"/iframe-a.html",
+		"/iframe-.html",
+		"/iframe-0.1.2.html",
+		"/iframe-0.1.2abc-dirty.2144.html",
+		"/iframe-a.html?t=1234",
+		"/iframe-0.1.2.html?t=123414",
+		"/iframe-0.1.2abc-dirty.2144.html?t=qweqweq123",
+	}
+	for _, u := range good {
+		verifyIframe(t, base+u)
+	}
+	bad := []string{"/iframe.htm", "/iframe", "/IFRAME.HTML", "/IFRAME", "/iframe.HTML", "/iframe.xml", "/iframe-/.html"}
+	for _, u := range bad {
+		r, _ := request(t, "GET", base+u, "")
+		expectStatus(t, r, http.StatusNotFound)
+	}
+}
+
+func TestProtocolIframeCacheability(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := server.URL + "/echo/iframe.html"
+	r1, _ := request(t, "GET", u, "")
+	r2, _ := request(t, "GET", u, "")
+	etag := r1.Header.Get("ETag")
+	if etag == "" || etag != r2.Header.Get("ETag") {
+		t.Errorf("ETags %q and %q", etag, r2.Header.Get("ETag"))
+	}
+	r, body := request(t, "GET", u, "", "If-None-Match", etag)
+	expectStatus(t, r, http.StatusNotModified)
+	expect(t, "Not modified Content-Type", r.Header.Get("Content-Type"), "")
+	expect(t, "Not modified body", body, "")
+}
+
+// Info
+
+func TestProtocolInfo(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	r, body := request(t, "GET", server.URL+"/echo/info", "")
+	expectStatus(t, r, http.StatusOK)
+	verifyContentType(t, r, "application/json; charset=UTF-8")
+	verifyNoCache(t, r)
+	verifyCors(t, r, "")
+	verifyNoCookie(t, r)
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("Info %q is not json: %v", body, err)
+	}
+	if len(data) != 4 {
+		t.Errorf("Info has keys %v", data)
+	}
+	if ws, ok := data["websocket"].(bool); !ok || !ws {
+		t.Errorf("Info websocket is %v", data["websocket"])
+	}
+	if c, ok := data["cookie_needed"].(bool); !ok || c {
+		t.Errorf("Info cookie_needed is %v", data["cookie_needed"])
+	}
+	if o, ok := data["origins"].([]interface{}); !ok || len(o) != 1 || o[0] != "*:*" {
+		t.Errorf("Info origins is %v", data["origins"])
+	}
+	if _, ok := data["entropy"].(float64); !ok {
+		t.Errorf("Info entropy is %v", data["entropy"])
+	}
+
+	entropies := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		_, body := request(t, "GET", server.URL+"/echo/info", "")
+		var data struct{ Entropy json.Number }
+		json.Unmarshal([]byte(body), &data)
+		if entropies[data.Entropy.String()] {
+			t.Errorf("Entropy %s repeated", data.Entropy)
+		}
+		entropies[data.Entropy.String()] = true
+	}
+
+	verifyOptions(t, server.URL+"/echo/info", "OPTIONS, GET")
+
+	for _, ep := range []struct {
+		url, key string
+		value    bool
+	}{
+		{"/disabled_websocket_echo/info", "websocket", false},
+		{"/cookie_needed_echo/info", "cookie_needed", true},
+	} {
+		_, body := request(t, "GET", server.URL+ep.url, "")
+		var data map[string]interface{}
+		json.Unmarshal([]byte(body), &data)
+		if data[ep.key] != ep.value {
+			t.Errorf("%s %s is %v", ep.url, ep.key, data[ep.key])
+		}
+	}
+}
+
+// Session urls
+
+func TestProtocolSessionUrls(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	base := server.URL + "/echo"
+	for _, ids := range []string{"/a/a", "/_/_", "/1/1", "/abcdefgh_i-j%20/abcdefg_i-j%20"} {
+		ids += randomId()
+		r, body := request(t, "POST", base+ids+"/xhr", "")
+		expectStatus(t, r, http.StatusOK)
+		expect(t, "Open frame for "+ids, body, "o\n")
+	}
+	for _, ids := range []string{"//", "/a./a", "/a/a.", "/./.", "/", "///"} {
+		r, _ := request(t, "GET", base+ids+"/xhr", "")
+		expectStatus(t, r, http.StatusNotFound)
+		r, _ = request(t, "POST", base+ids+"/xhr_send", "")
+		expectStatus(t, r, http.StatusNotFound)
+	}
+
+	// The server id doesn't matter.
+	sessionId := randomId()
+	r, body := request(t, "POST", base+"/000/"+sessionId+"/xhr", "")
+	expect(t, "Open", body, "o\n")
+	r, body = request(t, "POST", base+"/999/"+sessionId+"/xhr_send", `["a"]`)
+	expectStatus(t, r, http.StatusNoContent)
+	r, body = request(t, "POST", base+"/123/"+sessionId+"/xhr", "")
+	expect(t, "Message via another server id", body, "a[\"a\"]\n")
+}
+
+// Protocol
+
+func TestProtocolSimpleSession(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+
+	r, body := request(t, "POST", u+"/xhr", "")
+	expect(t, "Open", body, "o\n")
+	r, body = request(t, "POST", u+"/xhr_send", `["a"]`)
+	expectStatus(t, r, http.StatusNoContent)
+	expect(t, "xhr_send body", body, "")
+	r, body = request(t, "POST", u+"/xhr", "")
+	expect(t, "Poll", body, "a[\"a\"]\n")
+
+	r, _ = request(t, "POST", sessionUrl(server.URL+"/echo")+"/xhr_send", `["a"]`)
+	expectStatus(t, r, http.StatusNotFound)
+
+	// Another receiver while one is waiting.
+	resp, br := stream(t, "POST", u+"/xhr")
+	defer resp.Body.Close()
+	r, body = request(t, "POST", u+"/xhr", "")
+	expect(t, "Second receiver", body, "c[2010,\"Another connection still open\"]\n")
+	request(t, "POST", u+"/xhr_send", `["b"]`)
+	line, _ := br.ReadString('\n')
+	expect(t, "Waiting receiver", line, "a[\"b\"]\n")
+}
+
+func TestProtocolCloseSession(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/close")
+	_, body := request(t, "POST", u+"/xhr", "")
+	expect(t, "Open", body, "o\n")
+	for i := 0; i < 2; i++ {
+		_, body = request(t, "POST", u+"/xhr", "")
+		expect(t, "Closed session", body, "c[3000,\"Go away!\"]\n")
+	}
+}
+
+// Websockets
+
+func TestProtocolWebsocketHttpErrors(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL+"/echo") + "/websocket"
+
+	r, body := request(t, "GET", u, "")
+	expectStatus(t, r, http.StatusBadRequest)
+	expect(t, "No upgrade", body, "Can \"Upgrade\" only to \"WebSocket\".\n")
+
+	r, body = request(t, "GET", u, "", "Upgrade", "WebSocket", "Connection", "close")
+	expectStatus(t, r, http.StatusBadRequest)
+	expect(t, "Bad connection header", body, "\"Connection\" must be \"Upgrade\".\n")
+
+	r, _ = request(t, "POST", u, "", "Upgrade", "WebSocket", "Connection", "Upgrade")
+	expectStatus(t, r, http.StatusMethodNotAllowed)
+}
+
+func dialWebsocket(t *testing.T, u string) *websocket.Conn {
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(u, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Dialing %s: %v", u, err)
+	}
+	return ws
+}
+
+func receive(t *testing.T, ws *websocket.Conn) string {
+	var m string
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.Message.Receive(ws, &m); err != nil {
+		t.Errorf("Websocket receive: %v", err)
+	}
+	return m
+}
+
+func TestProtocolWebsocket(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+
+	ws := dialWebsocket(t, sessionUrl(server.URL+"/echo")+"/websocket")
+	expect(t, "Websocket open", receive(t, ws), "o")
+	websocket.Message.Send(ws, `["a"]`)
+	expect(t, "Websocket echo", receive(t, ws), `a["a"]`)
+	// Empty frames are ignored.
+	websocket.Message.Send(ws, "")
+	websocket.Message.Send(ws, `["b"]`)
+	expect(t, "Websocket echo after empty frame", receive(t, ws), `a["b"]`)
+	// Broken json closes the connection.
+	websocket.Message.Send(ws, `["a`)
+	var m string
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.Message.Receive(ws, &m); err == nil {
+		t.Errorf("Websocket with broken json received %q", m)
+	}
+	ws.Close()
+
+	ws = dialWebsocket(t, sessionUrl(server.URL+"/close")+"/websocket")
+	expect(t, "Websocket open", receive(t, ws), "o")
+	expect(t, "Websocket close", receive(t, ws), `c[3000,"Go away!"]`)
+	ws.Close()
+
+	r, _ := request(t, "GET", sessionUrl(server.URL+"/disabled_websocket_echo")+"/websocket", "")
+	expectStatus(t, r, http.StatusNotFound)
+}
+
+func TestProtocolRawWebsocket(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+
+	ws := dialWebsocket(t, server.URL+"/echo/websocket")
+	websocket.Message.Send(ws, "Hello world!￿")
+	expect(t, "Raw websocket echo", receive(t, ws), "Hello world!￿")
+	ws.Close()
+
+	ws = dialWebsocket(t, server.URL+"/close/websocket")
+	var m string
+	if err := websocket.Message.Receive(ws, &m); err != io.EOF {
+		t.Errorf("Raw websocket to /close received %q, %v", m, err)
+	}
+	ws.Close()
+
+	r, _ := request(t, "GET", server.URL+"/disabled_websocket_echo/websocket", "")
+	expectStatus(t, r, http.StatusNotFound)
+}
+
+// Xhr polling
+
+func TestProtocolXhrPolling(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	verifyOptions(t, u+"/xhr", "OPTIONS, POST")
+	verifyOptions(t, u+"/xhr_send", "OPTIONS, POST")
+
+	r, body := request(t, "POST", u+"/xhr", "", "Origin", "test")
+	expectStatus(t, r, http.StatusOK)
+	expect(t, "Open", body, "o\n")
+	verifyContentType(t, r, "application/javascript; charset=UTF-8")
+	verifyCors(t, r, "test")
+	verifyNoCache(t, r)
+
+	r, body = request(t, "POST", u+"/xhr_send", `["x"]`, "Origin", "test")
+	expectStatus(t, r, http.StatusNoContent)
+	expect(t, "xhr_send body", body, "")
+	verifyContentType(t, r, "text/plain; charset=UTF-8")
+	verifyCors(t, r, "test")
+	verifyNoCache(t, r)
+
+	_, body = request(t, "POST", u+"/xhr", "")
+	expect(t, "Poll", body, "a[\"x\"]\n")
+
+	// Bad json
+	r, body = request(t, "POST", u+"/xhr_send", `["x`)
+	expectStatus(t, r, http.StatusInternalServerError)
+	expect(t, "Broken json", body, "Broken JSON encoding.\n")
+	r, body = request(t, "POST", u+"/xhr_send", "")
+	expectStatus(t, r, http.StatusInternalServerError)
+	expect(t, "Empty payload", body, "Payload expected.\n")
+
+	// Content types don't matter.
+	ctypes := []string{"text/plain", "T", "application/json", "application/xml", "", "application/json; charset=utf-8", "text/xml; charset=utf-8", "text/xml"}
+	for _, ct := range ctypes {
+		r, _ = request(t, "POST", u+"/xhr_send", `["a"]`, "Content-Type", ct)
+		expectStatus(t, r, http.StatusNoContent)
+	}
+	_, body = request(t, "POST", u+"/xhr", "")
+	expect(t, "Poll", body, "a["+strings.TrimSuffix(strings.Repeat(`"a",`, len(ctypes)), ",")+"]\n")
+
+	// Requested headers are allowed.
+	for _, h := range []string{"a, b, c", ""} {
+		for _, suffix := range []string{"/xhr", "/xhr_send"} {
+			r, _ = request(t, "OPTIONS", u+suffix, "", "Access-Control-Request-Headers", h)
+			expect(t, "Access-Control-Allow-Headers", r.Header.Get("Access-Control-Allow-Headers"), h)
+		}
+	}
+}
+
+// Xhr streaming
+
+func TestProtocolXhrStreaming(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	verifyOptions(t, u+"/xhr_streaming", "OPTIONS, POST")
+
+	resp, br := stream(t, "POST", u+"/xhr_streaming")
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusOK)
+	verifyContentType(t, resp, "application/javascript; charset=UTF-8")
+	verifyCors(t, resp, "")
+	verifyNoCache(t, resp)
+	expect(t, "Prelude", readN(t, br, 2049), strings.Repeat("h", 2048)+"\n")
+	expect(t, "Open", readN(t, br, 2), "o\n")
+	request(t, "POST", u+"/xhr_send", `["x"]`)
+	line, _ := br.ReadString('\n')
+	expect(t, "Message", line, "a[\"x\"]\n")
+}
+
+// The streaming transports close the response once it has sent 4096 bytes
+// of frames.
+func verifyResponseLimit(t *testing.T, u string, method string, prelude, open int, frameLen int) {
+	resp, br := stream(t, method, u)
+	defer resp.Body.Close()
+	readN(t, br, prelude+open)
+	msg := strings.Repeat("x", 128)
+	js, _ := json.Marshal([]string{msg})
+	sendUrl := u[:strings.LastIndex(u, "/")] + "/xhr_send"
+	nframes := 0
+	for sent := 0; sent <= 4096; sent += frameLen {
+		request(t, "POST", sendUrl, string(js))
+		frame := readN(t, br, frameLen)
+		if !strings.Contains(frame, msg) {
+			t.Fatalf("Message %d was %q", nframes, frame)
+		}
+		nframes++
+	}
+	rest, err := ioutil.ReadAll(br)
+	if err != nil || len(rest) != 0 {
+		t.Errorf("Response did not end after %d frames: %q, %v", nframes, rest, err)
+	}
+}
+
+func TestProtocolResponseLimit(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	base := server.URL + "/echo"
+	frame := len(`a["` + strings.Repeat("x", 128) + `"]`)
+	verifyResponseLimit(t, sessionUrl(base)+"/xhr_streaming", "POST", 2049, 2, frame+1)
+	verifyResponseLimit(t, sessionUrl(base)+"/eventsource", "GET", 2, 11, frame+len("data: \r\n\r\n"))
+}
+
+// Eventsource
+
+func TestProtocolEventsource(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	resp, br := stream(t, "GET", u+"/eventsource")
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusOK)
+	verifyContentType(t, resp, "text/event-stream; charset=UTF-8")
+	verifyNoCache(t, resp)
+	expect(t, "Prelude", readN(t, br, 2), "\r\n")
+	expect(t, "Open", readN(t, br, 11), "data: o\r\n\r\n")
+	request(t, "POST", u+"/xhr_send", `["  \u0000\n\r "]`)
+	expected := "data: a[\"  \\u0000\\n\\r \"]\r\n\r\n"
+	expect(t, "Message", readN(t, br, len(expected)), expected)
+}
+
+// Htmlfile
+
+func TestProtocolHtmlfile(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	resp, br := stream(t, "GET", u+"/htmlfile?c=%63allback")
+	defer resp.Body.Close()
+	expectStatus(t, resp, http.StatusOK)
+	verifyContentType(t, resp, "text/html; charset=UTF-8")
+	verifyNoCache(t, resp)
+	var prelude []byte
+	for !strings.Contains(string(prelude), "</script>") || len(prelude) < 1024 {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("Reading prelude: %v", err)
+		}
+		prelude = append(prelude, line...)
+	}
+	if !strings.Contains(string(prelude), "var c = parent.callback;") {
+		t.Errorf("Prelude is\n%s", prelude)
+	}
+	// The rest of the padding
+	for {
+		line, _ := br.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			expect(t, "Open", line, "<script>\n")
+			break
+		}
+	}
+	expect(t, "Open", readN(t, br, len("p(\"o\");\n</script>\r\n")), "p(\"o\");\n</script>\r\n")
+	request(t, "POST", u+"/xhr_send", `["x"]`)
+	expected := "<script>\np(\"a[\\\"x\\\"]\");\n</script>\r\n"
+	expect(t, "Message", readN(t, br, len(expected)), expected)
+
+	r, body := request(t, "GET", sessionUrl(server.URL+"/echo")+"/htmlfile", "")
+	expectStatus(t, r, http.StatusInternalServerError)
+	expect(t, "No callback", body, "\"callback\" parameter required\n")
+}
+
+// Jsonp
+
+func TestProtocolJsonp(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	r, body := request(t, "GET", u+"/jsonp?c=%63allback", "")
+	expectStatus(t, r, http.StatusOK)
+	verifyContentType(t, r, "application/javascript; charset=UTF-8")
+	verifyNoCache(t, r)
+	expect(t, "Open", body, "callback(\"o\");\r\n")
+
+	form := "application/x-www-form-urlencoded"
+	r, body = request(t, "POST", u+"/jsonp_send", "d=%5B%22x%22%5D", "Content-Type", form)
+	expectStatus(t, r, http.StatusOK)
+	expect(t, "jsonp_send body", body, "ok")
+	verifyNoCookie(t, r)
+	_, body = request(t, "GET", u+"/jsonp?c=%63allback", "")
+	expect(t, "Poll", body, "callback(\"a[\\\"x\\\"]\");\r\n")
+
+	r, body = request(t, "GET", sessionUrl(server.URL+"/echo")+"/jsonp", "")
+	expectStatus(t, r, http.StatusInternalServerError)
+	expect(t, "No callback", body, "\"callback\" parameter required\n")
+
+	r, body = request(t, "POST", u+"/jsonp_send", "d=%5B%22x", "Content-Type", form)
+	expectStatus(t, r, http.StatusInternalServerError)
+	expect(t, "Broken json", body, "Broken JSON encoding.\n")
+	for _, data := range []string{"", "d=", "p=p"} {
+		r, body = request(t, "POST", u+"/jsonp_send", data, "Content-Type", form)
+		expectStatus(t, r, http.StatusInternalServerError)
+		expect(t, "Empty payload", body, "Payload expected.\n")
+	}
+
+	r, body = request(t, "POST", u+"/jsonp_send", `["b"]`, "Content-Type", "text/plain")
+	expectStatus(t, r, http.StatusOK)
+	_, body = request(t, "GET", u+"/jsonp?c=x", "")
+	expect(t, "Poll", body, "x(\"a[\\\"b\\\"]\");\r\n")
+
+	r, _ = request(t, "POST", sessionUrl(server.URL+"/echo")+"/jsonp_send", "d=%5B%22x%22%5D", "Content-Type", form)
+	expectStatus(t, r, http.StatusNotFound)
+
+	cu := sessionUrl(server.URL + "/close")
+	_, body = request(t, "GET", cu+"/jsonp?c=x", "")
+	expect(t, "Open", body, "x(\"o\");\r\n")
+	_, body = request(t, "GET", cu+"/jsonp?c=x", "")
+	expect(t, "Close", body, "x(\"c[3000,\\\"Go away!\\\"]\");\r\n")
+}
+
+// Cookies
+
+func TestProtocolJsessionid(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	base := server.URL + "/cookie_needed_echo"
+	r, _ := request(t, "GET", base+"/info", "")
+	expect(t, "Info cookie", r.Header.Get("Set-Cookie"), "JSESSIONID=dummy; Path=/")
+
+	transports := []struct{ method, suffix string }{
+		{"POST", "/xhr"},
+		{"POST", "/xhr_streaming"},
+		{"GET", "/eventsource"},
+		{"GET", "/htmlfile?c=x"},
+		{"GET", "/jsonp?c=x"},
+	}
+	for _, tr := range transports {
+		u := sessionUrl(base) + tr.suffix
+		resp, _ := stream(t, tr.method, u)
+		resp.Body.Close()
+		expect(t, tr.suffix+" cookie", resp.Header.Get("Set-Cookie"), "JSESSIONID=dummy; Path=/")
+
+		req, _ := http.NewRequest(tr.method, sessionUrl(base)+tr.suffix, nil)
+		req.Header.Set("Cookie", "JSESSIONID=abcdef")
+		req.Close = true
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		expect(t, tr.suffix+" echoed cookie", resp.Header.Get("Set-Cookie"), "JSESSIONID=abcdef; Path=/")
+	}
+
+	// No cookie if it is not needed.
+	r, _ = request(t, "POST", sessionUrl(server.URL+"/echo")+"/xhr", "")
+	verifyNoCookie(t, r)
+}
+
+// Json encoding
+
+func TestProtocolJsonEncoding(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	request(t, "POST", u+"/xhr", "")
+
+	// Characters that some browsers mangle must be escaped.
+	var escapable []rune
+	for c := rune(0); c < 0x20; c++ {
+		escapable = append(escapable, c)
+	}
+	for _, r := range [][2]rune{{0x200c, 0x200f}, {0x2028, 0x202f}, {0x2060, 0x206f}, {0xfff0, 0xffff}} {
+		for c := r[0]; c <= r[1]; c++ {
+			escapable = append(escapable, c)
+		}
+	}
+	js, _ := json.Marshal([]string{string(escapable)})
+	request(t, "POST", u+"/xhr_send", string(js))
+	_, body := request(t, "POST", u+"/xhr", "")
+	for _, c := range escapable {
+		if strings.ContainsRune(strings.TrimSuffix(body, "\n"), c) {
+			t.Errorf("Character %U was not escaped", c)
+		}
+	}
+	var msgs []string
+	if !strings.HasPrefix(body, "a") || json.Unmarshal([]byte(body[1:]), &msgs) != nil {
+		t.Fatalf("Bad message frame %q", body)
+	}
+	if len(msgs) != 1 || msgs[0] != string(escapable) {
+		t.Errorf("Escaped characters came back as %q", msgs)
+	}
+}
+
+// Closing
+
+func TestProtocolHandlingClose(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	u := sessionUrl(server.URL + "/echo")
+	resp, br := stream(t, "POST", u+"/xhr_streaming")
+	readN(t, br, 2049)
+	expect(t, "Open", readN(t, br, 2), "o\n")
+	resp2, br2 := stream(t, "POST", u+"/xhr_streaming")
+	readN(t, br2, 2049)
+	line, _ := br2.ReadString('\n')
+	expect(t, "Second streaming receiver", line, "c[2010,\"Another connection still open\"]\n")
+	resp2.Body.Close()
+	resp.Body.Close()
+}
+
+// Amplify and broadcast
+
+func TestProtocolAmplify(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	for _, transport := range gosockjstest.Transports {
+		c, err := gosockjstest.Dial(server.URL+"/amplify", transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		for _, n := range []int{0, 4, 12} {
+			c.Send(strconv.Itoa(n))
+			if err := c.ExpectMessages(strings.Repeat("x", 1<<uint(n))); err != nil {
+				t.Errorf("%s amplify %d: %v", transport, n, err)
+			}
+		}
+		// A long message is read whole.
+		c.Send(strings.Repeat("0", 5000) + "4")
+		if err := c.ExpectMessages(strings.Repeat("x", 16)); err != nil {
+			t.Errorf("%s amplify a long message: %v", transport, err)
+		}
+		c.Close()
+	}
+}
+
+func TestProtocolBroadcast(t *testing.T) {
+	server := startProtocolServer()
+	defer server.Close()
+	var clients []*gosockjstest.Client
+	for _, transport := range gosockjstest.Transports {
+		c, err := gosockjstest.Dial(server.URL+"/broadcast", transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.ExpectOpen(); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	// The sessions are open once their handlers are running, which the
+	// websocket and streaming clients can't tell us. Wait a moment.
+	time.Sleep(50 * time.Millisecond)
+	clients[0].Send("hello")
+	for _, c := range clients {
+		if err := c.ExpectMessages("hello"); err != nil {
+			t.Errorf("%s: %v", c.Transport, err)
+		}
+	}
+	long := strings.Repeat("y", 5000)
+	clients[1].Send(long)
+	for _, c := range clients {
+		if err := c.ExpectMessages(long); err != nil {
+			t.Errorf("%s long message: %v", c.Transport, err)
+		}
+	}
+}
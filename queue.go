@@ -0,0 +1,103 @@
+package gosockjs
+
+import (
+	"io"
+	"sync"
+)
+
+// messageQueue is a queue of inbound messages, which it reads out like a
+// connImpl does.
+type messageQueue struct {
+	queue  [][]byte
+	limit  int
+	unread []byte
+	closed bool
+	lock   sync.Mutex
+	cond   *sync.Cond
+}
+
+// newMessageQueue returns a queue that holds up to limit messages, or any
+// number if limit is 0.
+func newMessageQueue(limit int) *messageQueue {
+	q := &messageQueue{limit: limit}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// put adds a message, unless the queue is closed. It returns false if the
+// queue is full.
+func (q *messageQueue) put(data []byte) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return true
+	}
+	if q.limit > 0 && len(q.queue) >= q.limit {
+		return false
+	}
+	q.queue = append(q.queue, data)
+	q.cond.Signal()
+	return true
+}
+
+// close closes the queue. Messages already queued can still be read. It
+// returns false if the queue was already closed.
+func (q *messageQueue) close() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *messageQueue) isClosed() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.closed
+}
+
+// next waits for the next queued message. The lock must be held.
+func (q *messageQueue) next() ([]byte, error) {
+	for len(q.queue) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.queue) == 0 {
+		return nil, io.EOF
+	}
+	m := q.queue[0]
+	q.queue = q.queue[1:]
+	return m, nil
+}
+
+func (q *messageQueue) Read(data []byte) (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	m := q.unread
+	q.unread = nil
+	if m == nil {
+		var err error
+		m, err = q.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(data, m)
+	if n < len(m) {
+		q.unread = m[n:]
+	}
+	return n, nil
+}
+
+func (q *messageQueue) readMessage() ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.unread != nil {
+		m := q.unread
+		q.unread = nil
+		return m, nil
+	}
+	return q.next()
+}
@@ -0,0 +1,237 @@
+package gosockjs
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit describes a token bucket: Rate tokens are added every second, up
+// to Burst of them. A zero Rate means no limit; a zero Burst means Rate.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitPolicy says what happens when a session sends faster than its
+// limits allow.
+type RateLimitPolicy int
+
+const (
+	// RateLimitReject refuses the offending send. The session stays open.
+	RateLimitReject RateLimitPolicy = iota
+	// RateLimitDelay holds the send until the session is back within its
+	// limits, if that is no more than MaxRateLimitDelay away, and otherwise
+	// refuses it as RateLimitReject does.
+	RateLimitDelay
+	// RateLimitClose closes the session with CloseRateLimited.
+	RateLimitClose
+)
+
+// CloseRateLimited is the close code for sessions closed, or refused, for
+// exceeding their rate limits.
+const CloseRateLimited = 3429
+
+// MaxRateLimitDelay is the longest RateLimitDelay holds a send. A request's
+// handler waits out the delay, so it has to be short.
+const MaxRateLimitDelay = time.Second
+
+var RateLimited error = errors.New("Rate limit exceeded.")
+
+// BatchTooLarge refuses a send with more messages or bytes than its session's
+// limits could ever allow at once, however long the client waited.
+var BatchTooLarge error = errors.New("Batch exceeds the rate limit.")
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// newTokenBucket returns nil if there is no limit. A nil bucket allows everything.
+func newTokenBucket(l RateLimit) *tokenBucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = l.Rate
+	}
+	return &tokenBucket{rate: l.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take removes n tokens. If there are not enough it takes them anyway, going
+// into debt, and returns how long to wait until the debt is paid off, as long
+// as that is no more than maxWait. Otherwise it takes nothing and returns false.
+func (b *tokenBucket) take(n float64, maxWait time.Duration) (time.Duration, bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+	d := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if d > maxWait {
+		return 0, false
+	}
+	b.tokens -= n
+	return d, true
+}
+
+// refund puts back n tokens taken by take.
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// fits reports whether take could ever allow n tokens, starting from a full
+// bucket.
+func (b *tokenBucket) fits(n float64, maxWait time.Duration) bool {
+	if b == nil || n <= b.burst {
+		return true
+	}
+	return time.Duration((n-b.burst)/b.rate*float64(time.Second)) <= maxWait
+}
+
+// full reports whether the bucket has refilled completely, in which case it
+// may as well not exist.
+func (b *tokenBucket) full() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill()
+	return b.tokens >= b.burst
+}
+
+// The most clients whose session creation we track. Until the next sweep
+// forgets some idle ones, sessions from new clients beyond these are refused.
+const maxClientBuckets = 65536
+
+// How often the buckets of idle clients are forgotten.
+const clientBucketSweep = time.Minute
+
+// allowNewSession checks the per-client limit on session creation.
+func (r *Router) allowNewSession(remote string) bool {
+	if r.config.SessionRateLimit.Rate <= 0 {
+		return true
+	}
+	r.limitLock.Lock()
+	if r.clientBuckets == nil {
+		r.clientBuckets = make(map[string]*tokenBucket)
+	}
+	b := r.clientBuckets[remote]
+	if b == nil {
+		if len(r.clientBuckets) >= maxClientBuckets {
+			r.limitLock.Unlock()
+			return false
+		}
+		if len(r.clientBuckets) == 0 {
+			time.AfterFunc(clientBucketSweep, r.sweepClientBuckets)
+		}
+		b = newTokenBucket(r.config.SessionRateLimit)
+		r.clientBuckets[remote] = b
+	}
+	r.limitLock.Unlock()
+	_, ok := b.take(1, 0)
+	return ok
+}
+
+// sweepClientBuckets forgets the clients whose buckets have refilled, and
+// runs again later if any are left.
+func (r *Router) sweepClientBuckets() {
+	r.limitLock.Lock()
+	defer r.limitLock.Unlock()
+	for k, v := range r.clientBuckets {
+		if v.full() {
+			delete(r.clientBuckets, k)
+		}
+	}
+	if len(r.clientBuckets) > 0 {
+		time.AfterFunc(clientBucketSweep, r.sweepClientBuckets)
+	}
+}
+
+// checkRate charges a batch of inbound messages to the session's limits.
+func (s *session) checkRate(nmsgs, nbytes int) error {
+	policy := s.router.config.RateLimitPolicy
+	var wait time.Duration
+	if policy == RateLimitDelay {
+		wait = MaxRateLimitDelay
+	}
+	err := RateLimited
+	if !s.msgBucket.fits(float64(nmsgs), wait) || !s.byteBucket.fits(float64(nbytes), wait) {
+		// Waiting and retrying would not help.
+		err = BatchTooLarge
+	} else if d1, ok := s.msgBucket.take(float64(nmsgs), wait); ok {
+		d2, ok := s.byteBucket.take(float64(nbytes), wait)
+		if ok {
+			if d2 > d1 {
+				d1 = d2
+			}
+			if d1 > 0 {
+				time.Sleep(d1)
+			}
+			return nil
+		}
+		s.msgBucket.refund(float64(nmsgs))
+	}
+	if policy == RateLimitClose {
+		s.closeWith(CloseRateLimited, "Rate limit exceeded")
+	}
+	return err
+}
+
+// remoteHost returns the address of the client a request is from, without
+// the port. Requests from trusted proxies are from the last untrusted address
+// in their X-Forwarded-For header, which each proxy appends to.
+func (r *Router) remoteHost(req *http.Request) string {
+	host := addrHost(req.RemoteAddr)
+	if !r.config.trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = addrHost(hop)
+		if !r.config.trustedProxy(host) {
+			break
+		}
+	}
+	return host
+}
+
+// addrHost strips the port, if there is one, from an address.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,213 @@
+package gosockjs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var none *tokenBucket
+	if _, ok := none.take(1e9, 0); !ok {
+		t.Errorf("nil bucket refused")
+	}
+	if newTokenBucket(RateLimit{}) != nil {
+		t.Errorf("Zero rate limit made a bucket")
+	}
+
+	b := newTokenBucket(RateLimit{Rate: 100, Burst: 3})
+	for i := 0; i < 3; i++ {
+		if _, ok := b.take(1, 0); !ok {
+			t.Errorf("Take %d refused within burst", i)
+		}
+	}
+	if _, ok := b.take(1, 0); ok {
+		t.Errorf("Take allowed beyond burst")
+	}
+	if _, ok := b.take(200, time.Second); ok {
+		t.Errorf("Take allowed beyond its longest wait")
+	}
+	d, ok := b.take(2, time.Second)
+	if !ok || d < 15*time.Millisecond || d > 20*time.Millisecond {
+		t.Errorf("Waiting take gave %v, %v; expected about 20ms", d, ok)
+	}
+	time.Sleep(d + 10*time.Millisecond)
+	if _, ok := b.take(1, 0); !ok {
+		t.Errorf("Bucket did not refill")
+	}
+}
+
+func TestSessionRateLimit(t *testing.T) {
+	r := &Router{config: Config{
+		DisconnectDelay:  time.Minute,
+		MessageRateLimit: RateLimit{Rate: 0.001, Burst: 2},
+		RateLimitPolicy:  RateLimitReject,
+	}}
+	s := newSession(r, "", "")
+	trans := new(recordingTransport)
+	s.trans = trans
+	if err := s.fromClient(message(`["a","b"]`)); err != nil {
+		t.Errorf("First send returned %v", err)
+	}
+	if err := s.fromClient(message(`"c"`)); err != RateLimited {
+		t.Errorf("Send over the limit returned %v", err)
+	}
+	if s.isClosed() {
+		t.Errorf("Rejecting policy closed the session")
+	}
+	s.Close()
+
+	r.config.RateLimitPolicy = RateLimitDelay
+	s = newSession(r, "", "")
+	s.trans = trans
+	s.fromClient(message(`["a","b"]`))
+	start := time.Now()
+	if err := s.fromClient(message(`"c"`)); err != RateLimited {
+		t.Errorf("Send too far over the limit returned %v", err)
+	}
+	if d := time.Since(start); d > MaxRateLimitDelay {
+		t.Errorf("Delaying policy held the send for %v", d)
+	}
+	s.Close()
+
+	// A send not too far over the limit waits.
+	r.config.MessageRateLimit = RateLimit{Rate: 20, Burst: 2}
+	s = newSession(r, "", "")
+	s.trans = trans
+	s.fromClient(message(`["a","b"]`))
+	start = time.Now()
+	if err := s.fromClient(message(`"c"`)); err != nil {
+		t.Errorf("Delayed send returned %v", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond || d > MaxRateLimitDelay {
+		t.Errorf("Delaying policy held the send for %v; expected about 50ms", d)
+	}
+	s.Close()
+	r.config.MessageRateLimit = RateLimit{Rate: 0.001, Burst: 2}
+
+	r.config.RateLimitPolicy = RateLimitClose
+	s = newSession(r, "", "")
+	s.trans = trans
+	s.fromClient(message(`["a","b","c"]`))
+	if !s.isClosed() {
+		t.Errorf("Closing policy did not close the session")
+	}
+	if f := trans.frames[len(trans.frames)-1]; string(f) != `c[3429,"Rate limit exceeded"]` {
+		t.Errorf("Close frame was %s", f)
+	}
+}
+
+func TestRateLimitRefusals(t *testing.T) {
+	r := &Router{config: Config{
+		DisconnectDelay:  time.Minute,
+		MessageRateLimit: RateLimit{Rate: 0.001, Burst: 2},
+		ByteRateLimit:    RateLimit{Rate: 0.001, Burst: 3},
+		RateLimitPolicy:  RateLimitReject,
+	}}
+	s := newSession(r, "", "")
+	s.trans = new(recordingTransport)
+	defer s.Close()
+	// More messages than the burst can never be sent.
+	if err := s.fromClient(message(`["a","b","c"]`)); err != BatchTooLarge {
+		t.Errorf("Oversized batch returned %v", err)
+	}
+	// A send the byte limit refuses costs no messages.
+	if err := s.fromClient(message(`"abcd"`)); err != BatchTooLarge {
+		t.Errorf("Oversized message returned %v", err)
+	}
+	if err := s.fromClient(message(`["ab","c"]`)); err != nil {
+		t.Errorf("Send within the limits returned %v", err)
+	}
+	if err := s.fromClient(message(`"d"`)); err != RateLimited {
+		t.Errorf("Send over the limits returned %v", err)
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	r := &Router{config: Config{
+		DisconnectDelay:  time.Minute,
+		MessageRateLimit: RateLimit{Rate: 0.001, Burst: 2},
+		ByteRateLimit:    RateLimit{Rate: 0.001, Burst: 3},
+		RateLimitPolicy:  RateLimitReject,
+	}}
+	s := newSession(r, "", "")
+	s.trans = new(recordingTransport)
+	defer s.Close()
+	if err := s.fromClient(message(`"abc"`)); err != nil {
+		t.Errorf("First send returned %v", err)
+	}
+	// The byte bucket is empty, so this is refused, and the message it
+	// would have used is refunded.
+	if err := s.fromClient(message(`"d"`)); err != RateLimited {
+		t.Errorf("Send over the byte limit returned %v", err)
+	}
+	if _, ok := s.msgBucket.take(1, 0); !ok {
+		t.Errorf("Refused send used up message quota")
+	}
+}
+
+func TestNewSessionRateLimit(t *testing.T) {
+	r := &Router{config: Config{SessionRateLimit: RateLimit{Rate: 0.001, Burst: 1}}}
+	if !r.allowNewSession("1.2.3.4") {
+		t.Errorf("First session refused")
+	}
+	if r.allowNewSession("1.2.3.4") {
+		t.Errorf("Second session allowed")
+	}
+	if !r.allowNewSession("5.6.7.8") {
+		t.Errorf("Session from another client refused")
+	}
+
+	// Clients whose buckets have refilled are forgotten.
+	r.clientBuckets["5.6.7.8"].refund(1)
+	r.sweepClientBuckets()
+	if n := len(r.clientBuckets); n != 1 {
+		t.Errorf("After a sweep %d clients are tracked, not 1", n)
+	}
+}
+
+func TestSessionLimitsKeepRate(t *testing.T) {
+	r := &Router{config: Config{
+		MaxSessions:      1,
+		SessionRateLimit: RateLimit{Rate: 0.001, Burst: 2},
+	}}
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	if err := r.countNewSession("1.2.3.4"); err != nil {
+		t.Errorf("First session refused: %v", err)
+	}
+	// Refused for the session limit, which costs no rate.
+	if err := r.countNewSession("1.2.3.4"); err != TooManySessions {
+		t.Errorf("Session over the limit gave %v", err)
+	}
+	r.uncountSession("1.2.3.4")
+	if err := r.countNewSession("1.2.3.4"); err != nil {
+		t.Errorf("Session once there was room gave %v", err)
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	r := &Router{config: Config{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"}}}
+	tests := []struct {
+		remoteAddr, forwardedFor, host string
+	}{
+		{"1.2.3.4:5678", "", "1.2.3.4"},
+		// Only trusted proxies can forward.
+		{"1.2.3.4:5678", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:5678", "5.6.7.8", "5.6.7.8"},
+		// Addresses the client claims are only believed behind proxies.
+		{"10.0.0.1:5678", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"10.0.0.1:5678", "192.168.1.1", "192.168.1.1"},
+		{"10.0.0.1:5678", "", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if host := r.remoteHost(req); host != test.host {
+			t.Errorf("%s forwarding for %q is %s, not %s", test.remoteAddr, test.forwardedFor, host, test.host)
+		}
+	}
+}
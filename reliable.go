@@ -0,0 +1,312 @@
+package gosockjs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReliableConn adds delivery guarantees to a Conn. A SockJS session can lose
+// outbound messages when a receiving connection dies, so ReliableConn numbers
+// every message it sends and keeps it until the client acknowledges it,
+// sending it again every RetransmitInterval until then.
+//
+// The client must speak a small protocol on top of SockJS. The server sends
+// JSON objects {"seq": n, "data": "..."}, with seq counting up from 1. Messages
+// are first sent in order, but one lost with a receiving connection arrives
+// later, by retransmission, so the client may see gaps; it should ignore a
+// seq it has already seen, which also happens when a retransmission crosses
+// an acknowledgement. The client sends {"data": "..."} for its own messages
+// and {"ack": n} to acknowledge every message up to and including n, so n
+// must be a seq it has every message up to; the two may be combined.
+//
+// Messages from the client are queued for Read. If the handler falls more
+// than 1024 behind, the connection is closed.
+//
+// The data is the message as a JSON string, so it must be text unless the
+// ReliableConn is in binary mode. Then it is base64-encoded, in both
+// directions. This is separate from the Conn's binary mode: in a binary
+// session the session base64-encodes each whole JSON object again.
+//
+// At most MaxUnacknowledged messages are kept for retransmission. Once that
+// many are waiting for the client, Send fails with TooManyUnacknowledged.
+type ReliableConn struct {
+	// RetransmitInterval is how long to wait for an acknowledgement before
+	// sending a message again, and MaxUnacknowledged how many messages may
+	// wait. Set them before writing.
+	RetransmitInterval time.Duration
+	MaxUnacknowledged  int
+
+	conn        *Conn
+	onDelivered func(seq uint64, data []byte)
+
+	lock       sync.Mutex
+	sendLock   sync.Mutex
+	nextSeq    uint64
+	unacked    []reliableMessage
+	timer      *time.Timer
+	closed     bool
+	binaryMode bool
+	inbox      chan []byte
+	unread     []byte
+	readLock   sync.Mutex
+}
+
+type reliableMessage struct {
+	seq  uint64
+	data []byte
+	sent time.Time
+}
+
+// The json forms of reliable messages. Data is a string, or a []byte to be
+// base64-encoded.
+type reliableOut struct {
+	Seq  uint64      `json:"seq"`
+	Data interface{} `json:"data"`
+}
+
+type reliableIn struct {
+	Ack  *uint64 `json:"ack"`
+	Data *string `json:"data"`
+}
+
+const DefaultRetransmitInterval = 5 * time.Second
+const DefaultMaxUnacknowledged = 1024
+
+var TooManyUnacknowledged error = errors.New("Too many unacknowledged messages.")
+
+// NewReliableConn wraps c. If onDelivered is not nil it is called, in order,
+// for every message the client acknowledges. The ReliableConn reads c itself,
+// so the handler must read from the ReliableConn instead.
+func NewReliableConn(c *Conn, onDelivered func(seq uint64, data []byte)) *ReliableConn {
+	r := &ReliableConn{
+		RetransmitInterval: DefaultRetransmitInterval,
+		MaxUnacknowledged:  DefaultMaxUnacknowledged,
+		conn:               c,
+		onDelivered:        onDelivered,
+		nextSeq:            1,
+		inbox:              make(chan []byte, 1024),
+	}
+	go r.receive()
+	return r
+}
+
+// receive reads the connection, handling acknowledgements and queueing
+// messages for Read.
+func (r *ReliableConn) receive() {
+	defer close(r.inbox)
+	defer r.shut()
+	for {
+		m, err := r.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in reliableIn
+		if err := json.Unmarshal(m, &in); err != nil {
+			log.Println("ReliableConn: bad message from client:", err)
+			continue
+		}
+		if in.Ack != nil {
+			r.acknowledge(*in.Ack)
+		}
+		if in.Data != nil {
+			data := []byte(*in.Data)
+			if r.binary() {
+				data, err = base64.StdEncoding.DecodeString(*in.Data)
+				if err != nil {
+					log.Println("ReliableConn: bad message from client:", err)
+					continue
+				}
+			}
+			select {
+			case r.inbox <- data:
+			default:
+				// Don't stop handling acks for a handler that isn't reading.
+				log.Println("ReliableConn: message queue full")
+				r.Close()
+				return
+			}
+		}
+	}
+}
+
+func (r *ReliableConn) acknowledge(ack uint64) {
+	r.lock.Lock()
+	var delivered []reliableMessage
+	for len(r.unacked) > 0 && r.unacked[0].seq <= ack {
+		delivered = append(delivered, r.unacked[0])
+		r.unacked = r.unacked[1:]
+	}
+	if len(r.unacked) == 0 && r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.lock.Unlock()
+	if r.onDelivered != nil {
+		for _, m := range delivered {
+			r.onDelivered(m.seq, m.data)
+		}
+	}
+}
+
+// SetBinary turns binary mode on or off. Set it before reading or writing.
+func (r *ReliableConn) SetBinary(binary bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.binaryMode = binary
+}
+
+func (r *ReliableConn) binary() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.binaryMode
+}
+
+// shut stops retransmitting. Later sends fail.
+func (r *ReliableConn) shut() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+func (r *ReliableConn) send(m reliableMessage) error {
+	out := reliableOut{Seq: m.seq, Data: string(m.data)}
+	if r.binary() {
+		out.Data = m.data
+	}
+	js, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.Write(js)
+	return err
+}
+
+// retransmit sends again the unacknowledged messages that were last sent at
+// least RetransmitInterval ago, and waits for the next one to come due.
+func (r *ReliableConn) retransmit() {
+	r.sendLock.Lock()
+	defer r.sendLock.Unlock()
+	r.lock.Lock()
+	if r.closed || len(r.unacked) == 0 {
+		r.timer = nil
+		r.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	var pending []reliableMessage
+	next := now
+	for i := range r.unacked {
+		m := &r.unacked[i]
+		if now.Sub(m.sent) >= r.RetransmitInterval {
+			m.sent = now
+			pending = append(pending, *m)
+		}
+		if m.sent.Before(next) {
+			next = m.sent
+		}
+	}
+	r.timer = time.AfterFunc(next.Add(r.RetransmitInterval).Sub(now), r.retransmit)
+	r.lock.Unlock()
+	for _, m := range pending {
+		if err := r.send(m); err != nil {
+			return
+		}
+	}
+}
+
+// Send sends a message, returning its sequence number.
+func (r *ReliableConn) Send(data []byte) (uint64, error) {
+	// Hold sendLock until the message is written, so that messages go out
+	// in seq order.
+	r.sendLock.Lock()
+	defer r.sendLock.Unlock()
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return 0, io.EOF
+	}
+	if r.MaxUnacknowledged > 0 && len(r.unacked) >= r.MaxUnacknowledged {
+		r.lock.Unlock()
+		return 0, TooManyUnacknowledged
+	}
+	m := reliableMessage{r.nextSeq, append([]byte(nil), data...), time.Now()}
+	r.nextSeq++
+	r.unacked = append(r.unacked, m)
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.RetransmitInterval, r.retransmit)
+	}
+	r.lock.Unlock()
+	// The message is kept for retransmission even if this fails.
+	return m.seq, r.send(m)
+}
+
+func (r *ReliableConn) Write(data []byte) (int, error) {
+	if _, err := r.Send(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// next returns the next message, or the rest of one partly read. The caller
+// must hold readLock.
+func (r *ReliableConn) next() ([]byte, error) {
+	if r.unread != nil {
+		m := r.unread
+		r.unread = nil
+		return m, nil
+	}
+	m, ok := <-r.inbox
+	if !ok {
+		return nil, io.EOF
+	}
+	return m, nil
+}
+
+// ReadMessage reads the next message from the client.
+func (r *ReliableConn) ReadMessage() ([]byte, error) {
+	r.readLock.Lock()
+	defer r.readLock.Unlock()
+	return r.next()
+}
+
+func (r *ReliableConn) Read(data []byte) (int, error) {
+	r.readLock.Lock()
+	defer r.readLock.Unlock()
+	m, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	n := copy(data, m)
+	if n < len(m) {
+		r.unread = m[n:]
+	}
+	return n, nil
+}
+
+// Unacknowledged returns the messages the client has not acknowledged, by
+// sequence number. After the connection closes these are the messages that
+// may not have been delivered.
+func (r *ReliableConn) Unacknowledged() map[uint64][]byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pending := make(map[uint64][]byte)
+	for _, m := range r.unacked {
+		pending[m.seq] = m.data
+	}
+	return pending
+}
+
+// Close stops retransmitting and closes the connection.
+func (r *ReliableConn) Close() error {
+	r.shut()
+	return r.conn.Close()
+}
@@ -0,0 +1,194 @@
+package gosockjs_test
+
+import (
+	"encoding/base64"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"testing"
+	"time"
+)
+
+func TestReliableConn(t *testing.T) {
+	delivered := make(chan uint64, 10)
+	conns := make(chan *gosockjs.ReliableConn, 1)
+	server := gosockjstest.NewServer("/reliable", func(c *gosockjs.Conn) {
+		rc := gosockjs.NewReliableConn(c, func(seq uint64, data []byte) {
+			delivered <- seq
+		})
+		rc.RetransmitInterval = 100 * time.Millisecond
+		conns <- rc
+		for {
+			m, err := rc.ReadMessage()
+			if err != nil {
+				return
+			}
+			rc.Write(m)
+		}
+	})
+	defer server.Close()
+
+	for _, transport := range []string{gosockjstest.Websocket, gosockjstest.XhrStreaming} {
+		c, err := gosockjstest.Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		rc := <-conns
+		c.Send(`{"data":"one"}`, `{"data":"two"}`)
+		if err := c.ExpectMessages(`{"seq":1,"data":"one"}`, `{"seq":2,"data":"two"}`); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		// Unacknowledged messages are sent again.
+		c.Send(`{"ack":1}`)
+		if seq := <-delivered; seq != 1 {
+			t.Errorf("%s: delivered %d, not 1", transport, seq)
+		}
+		if err := c.ExpectMessages(`{"seq":2,"data":"two"}`); err != nil {
+			t.Errorf("%s: retransmission: %v", transport, err)
+		}
+		c.Send(`{"ack":2,"data":"three"}`)
+		if seq := <-delivered; seq != 2 {
+			t.Errorf("%s: delivered %d, not 2", transport, seq)
+		}
+		if err := c.ExpectMessages(`{"seq":3,"data":"three"}`); err != nil {
+			t.Errorf("%s: %v", transport, err)
+		}
+		c.Close()
+		rc.Close()
+		pending := rc.Unacknowledged()
+		if len(pending) != 1 || string(pending[3]) != "three" {
+			t.Errorf("%s: unacknowledged messages were %v", transport, pending)
+		}
+		if _, err := rc.Send([]byte("four")); err == nil {
+			t.Errorf("%s: Send after Close succeeded", transport)
+		}
+	}
+}
+
+func TestReliableConnRetransmit(t *testing.T) {
+	server := gosockjstest.NewServer("/reliable", func(c *gosockjs.Conn) {
+		rc := gosockjs.NewReliableConn(c, nil)
+		rc.RetransmitInterval = 200 * time.Millisecond
+		rc.Write([]byte("a"))
+		time.Sleep(120 * time.Millisecond)
+		rc.Write([]byte("b"))
+		rc.ReadMessage()
+	})
+	defer server.Close()
+
+	c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectOpen()
+	if err := c.ExpectMessages(`{"seq":1,"data":"a"}`, `{"seq":2,"data":"b"}`, `{"seq":1,"data":"a"}`); err != nil {
+		t.Fatal(err)
+	}
+	// b is only resent once it has gone unacknowledged for the interval.
+	start := time.Now()
+	if err := c.ExpectMessages(`{"seq":2,"data":"b"}`); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("b was resent %v after a", d)
+	}
+}
+
+func TestReliableConnBinary(t *testing.T) {
+	enc := base64.StdEncoding.EncodeToString
+	for _, session := range []bool{false, true} {
+		server := gosockjstest.NewServer("/reliable", func(c *gosockjs.Conn) {
+			c.SetBinary(session)
+			rc := gosockjs.NewReliableConn(c, nil)
+			rc.SetBinary(true)
+			rc.Write([]byte{0xff, 0, 1})
+			for {
+				m, err := rc.ReadMessage()
+				if err != nil {
+					return
+				}
+				rc.Write(m)
+			}
+		})
+
+		c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		// A binary session encodes the whole envelope again.
+		frame := func(s string) string {
+			if session {
+				return enc([]byte(s))
+			}
+			return s
+		}
+		if err := c.ExpectMessages(frame(`{"seq":1,"data":"/wAB"}`)); err != nil {
+			t.Errorf("Binary session %v: %v", session, err)
+		}
+		c.Send(frame(`{"ack":1,"data":"AP8="}`))
+		if err := c.ExpectMessages(frame(`{"seq":2,"data":"AP8="}`)); err != nil {
+			t.Errorf("Binary session %v: %v", session, err)
+		}
+		c.Close()
+		server.Close()
+	}
+}
+
+func TestReliableConnMaxUnacknowledged(t *testing.T) {
+	errs := make(chan error, 1)
+	server := gosockjstest.NewServer("/reliable", func(c *gosockjs.Conn) {
+		rc := gosockjs.NewReliableConn(c, nil)
+		rc.MaxUnacknowledged = 2
+		rc.Write([]byte("a"))
+		rc.Write([]byte("b"))
+		_, err := rc.Write([]byte("c"))
+		errs <- err
+		rc.ReadMessage()
+	})
+	defer server.Close()
+
+	c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectOpen()
+	if err := <-errs; err != gosockjs.TooManyUnacknowledged {
+		t.Errorf("Writing past the limit returned %v", err)
+	}
+	if err := c.ExpectMessages(`{"seq":1,"data":"a"}`, `{"seq":2,"data":"b"}`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReliableConnOverflow(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	server := gosockjstest.NewServer("/reliable", func(c *gosockjs.Conn) {
+		// A handler that never reads.
+		gosockjs.NewReliableConn(c, nil)
+		<-done
+	})
+	defer server.Close()
+
+	c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ExpectOpen()
+	// In batches small enough for the session's own queue.
+	batch := make([]string, 100)
+	for i := range batch {
+		batch[i] = `{"data":"x"}`
+	}
+	for i := 0; i < 11; i++ {
+		c.Send(batch...)
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.ExpectClose(3000); err != nil {
+		t.Errorf("Overflowing the queue: %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+package gosockjs
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Resumer keeps a logical connection, and its handler, alive across SockJS
+// sessions, so a client whose session dies (say, a phone changing networks)
+// can carry on where it left off in a new one.
+//
+// The client must speak a small protocol on top of SockJS. Its first message
+// in each session is a hello: {"resume": token, "seq": n} to resume the
+// connection with that token, having received messages up to n, or
+// {"resume": ""} to start a new one. The server answers with {"token": token} and then
+// sends each message as {"seq": n, "data": "..."}, with seq counting up from
+// 1. On a resume it first replays the messages after n. If the connection
+// cannot be resumed, because it timed out or too many messages were missed,
+// the token will be a new one, with a new handler. A first message that is
+// not a hello is the first message of the new connection.
+//
+// Messages from the client are passed on as they are. Messages it sent while
+// it was reconnecting may be lost. If the handler falls more than 1024
+// messages behind, the logical connection is closed.
+//
+// The data is the message as a JSON string, so it must be text unless the
+// handler's Conn is in binary mode. Then the data is base64-encoded, and so
+// are the client's messages. The handler's Conn starts in text mode whatever
+// the sessions' mode; a binary session base64-encodes each whole frame again.
+type Resumer struct {
+	// Timeout is how long a logical connection waits to be resumed after its
+	// session ends.
+	Timeout time.Duration
+	// BufferSize is the number of recent messages kept for replay.
+	BufferSize int
+
+	handler Handler
+	conns   map[string]*resumable
+	lock    sync.Mutex
+}
+
+// NewResumer returns a Resumer that serves each logical connection with h.
+// The Resumer's Serve method is the Handler to install.
+func NewResumer(h Handler) *Resumer {
+	return &Resumer{
+		Timeout:    30 * time.Second,
+		BufferSize: 100,
+		handler:    h,
+		conns:      make(map[string]*resumable),
+	}
+}
+
+type resumeHello struct {
+	Resume *string `json:"resume"`
+	Seq    uint64  `json:"seq"`
+}
+
+type resumeToken struct {
+	Token string `json:"token"`
+}
+
+// resumeMessage is a message sent to the client. Data is a string, or a
+// []byte to be base64-encoded.
+type resumeMessage struct {
+	Seq  uint64      `json:"seq"`
+	Data interface{} `json:"data"`
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Serve is the Handler for the sessions of a Resumer.
+func (rs *Resumer) Serve(c *Conn) {
+	hello, err := c.ReadMessage()
+	if err != nil {
+		return
+	}
+	var h resumeHello
+	var rc *resumable
+	isHello := json.Unmarshal(hello, &h) == nil && h.Resume != nil
+	if isHello {
+		rc = rs.resume(*h.Resume, h.Seq, c)
+	}
+	if rc == nil {
+		rc = rs.create(c)
+		if !isHello && !rc.receive(hello) {
+			return
+		}
+		go rs.handler(&Conn{rc})
+	}
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			rc.detach(c)
+			return
+		}
+		if !rc.receive(m) {
+			return
+		}
+	}
+}
+
+// create starts a new logical connection on c.
+func (rs *Resumer) create(c *Conn) *resumable {
+	rc := &resumable{
+		messageQueue: newMessageQueue(maxResumableQueue),
+		rs:           rs,
+		token:        newResumeToken(),
+	}
+	rs.lock.Lock()
+	rs.conns[rc.token] = rc
+	rs.lock.Unlock()
+	rc.attach(c, 0)
+	return rc
+}
+
+// resume moves the logical connection with the token to c, or returns nil if
+// it can't.
+func (rs *Resumer) resume(token string, seq uint64, c *Conn) *resumable {
+	rs.lock.Lock()
+	rc := rs.conns[token]
+	rs.lock.Unlock()
+	if rc == nil || !rc.attach(c, seq) {
+		return nil
+	}
+	return rc
+}
+
+// expire ends a logical connection that was not resumed in time.
+func (rs *Resumer) expire(rc *resumable) {
+	rc.lock.Lock()
+	expired := rc.conn == nil
+	rc.lock.Unlock()
+	if expired {
+		rc.Close()
+	}
+}
+
+// The most client messages a logical connection queues for its handler.
+const maxResumableQueue = 1024
+
+// resumable is the connImpl of a logical connection.
+type resumable struct {
+	*messageQueue
+	rs    *Resumer
+	token string
+
+	lock       sync.Mutex
+	conn       *Conn
+	seq        uint64
+	history    []resumeMessage
+	timer      *time.Timer
+	binaryMode bool
+}
+
+// receive queues a message from the client. If the handler is too far
+// behind it closes the connection and returns false.
+func (rc *resumable) receive(m []byte) bool {
+	if rc.binary() {
+		data, err := base64.StdEncoding.DecodeString(string(m))
+		if err != nil {
+			log.Println("Resumer: bad message from client:", err)
+			return true
+		}
+		m = data
+	}
+	if !rc.put(m) {
+		log.Println("Resumer: message queue full")
+		rc.Close()
+		return false
+	}
+	return true
+}
+
+func (rc *resumable) setBinary(binary bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.binaryMode = binary
+}
+
+func (rc *resumable) binary() bool {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.binaryMode
+}
+
+// attach makes c the connection's current session, replaying the messages
+// after seq. It returns false if some of them are gone.
+func (rc *resumable) attach(c *Conn, seq uint64) bool {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.isClosed() || seq > rc.seq {
+		return false
+	}
+	if seq < rc.seq && (len(rc.history) == 0 || rc.history[0].Seq > seq+1) {
+		return false
+	}
+	if rc.timer != nil {
+		rc.timer.Stop()
+		rc.timer = nil
+	}
+	old := rc.conn
+	rc.conn = c
+	if old != nil {
+		// The client has moved on without the old session noticing.
+		old.Close()
+	}
+	rc.send(resumeToken{rc.token})
+	for _, m := range rc.history {
+		if m.Seq > seq {
+			rc.send(m)
+		}
+	}
+	return true
+}
+
+// detach forgets c, if it is still the current session, and waits for the
+// client to resume.
+func (rc *resumable) detach(c *Conn) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.conn != c || rc.isClosed() {
+		return
+	}
+	rc.conn = nil
+	rc.timer = time.AfterFunc(rc.rs.Timeout, func() { rc.rs.expire(rc) })
+}
+
+// send writes v to the current session, if there is one. The lock must be
+// held.
+func (rc *resumable) send(v interface{}) {
+	if rc.conn == nil {
+		return
+	}
+	js, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	// If this fails the session is going away, and the message will be
+	// replayed when the client comes back.
+	rc.conn.Write(js)
+}
+
+func (rc *resumable) Write(data []byte) (int, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.isClosed() {
+		return 0, io.EOF
+	}
+	rc.seq++
+	m := resumeMessage{rc.seq, string(data)}
+	if rc.binaryMode {
+		m.Data = append([]byte(nil), data...)
+	}
+	rc.history = append(rc.history, m)
+	if n := len(rc.history) - rc.rs.BufferSize; n > 0 {
+		rc.history = rc.history[n:]
+	}
+	rc.send(m)
+	return len(data), nil
+}
+
+// Close ends the logical connection, and closes its current session.
+func (rc *resumable) Close() error {
+	if !rc.close() {
+		return nil
+	}
+	rc.rs.lock.Lock()
+	delete(rc.rs.conns, rc.token)
+	rc.rs.lock.Unlock()
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.timer != nil {
+		rc.timer.Stop()
+		rc.timer = nil
+	}
+	if rc.conn != nil {
+		rc.conn.Close()
+		rc.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package gosockjs_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resumeHello starts a session of a Resumer, sending hello as its first
+// message, and returns its client.
+func resumeHello(t *testing.T, baseUrl, transport, hello string) *gosockjstest.Client {
+	c, err := gosockjstest.Dial(baseUrl, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ExpectOpen()
+	c.Send(hello)
+	return c
+}
+
+func readToken(t *testing.T, c *gosockjstest.Client) string {
+	m, err := c.ReadMessage()
+	var token struct{ Token string }
+	if err != nil || json.Unmarshal([]byte(m), &token) != nil || token.Token == "" {
+		t.Fatalf("Expected a token, got %q, %v", m, err)
+	}
+	return token.Token
+}
+
+func TestResumer(t *testing.T) {
+	started := make(chan *gosockjs.Conn, 10)
+	ended := make(chan bool, 10)
+	rs := gosockjs.NewResumer(func(c *gosockjs.Conn) {
+		started <- c
+		defer func() { ended <- true }()
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("echo:" + string(m)))
+		}
+	})
+	rs.Timeout = 200 * time.Millisecond
+	server := gosockjstest.NewServer("/resume", rs.Serve)
+	defer server.Close()
+
+	c1 := resumeHello(t, server.BaseUrl, gosockjstest.XhrStreaming, `{"resume":""}`)
+	token := readToken(t, c1)
+	conn := <-started
+	c1.Send("a", "b")
+	if err := c1.ExpectMessages(`{"seq":1,"data":"echo:a"}`, `{"seq":2,"data":"echo:b"}`); err != nil {
+		t.Error(err)
+	}
+	// The client goes away, missing a message.
+	c1.Close()
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte("c"))
+
+	c2 := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":"`+token+`","seq":2}`)
+	if tok := readToken(t, c2); tok != token {
+		t.Errorf("Resumed with token %s, not %s", tok, token)
+	}
+	if err := c2.ExpectMessages(`{"seq":3,"data":"c"}`); err != nil {
+		t.Error(err)
+	}
+	c2.Send("d")
+	if err := c2.ExpectMessages(`{"seq":4,"data":"echo:d"}`); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-started:
+		t.Errorf("Resuming started another handler")
+	default:
+	}
+
+	// Unknown tokens give a new connection.
+	c3 := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":"nonsense","seq":1}`)
+	if tok := readToken(t, c3); tok == token {
+		t.Errorf("Unknown token resumed the connection")
+	}
+	<-started
+	c3.Close()
+
+	// So do tokens that have timed out.
+	c2.Close()
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatalf("Connection did not time out")
+	}
+	c4 := resumeHello(t, server.BaseUrl, gosockjstest.XhrStreaming, `{"resume":"`+token+`","seq":4}`)
+	if tok := readToken(t, c4); tok == token {
+		t.Errorf("Timed out token resumed the connection")
+	}
+	c4.Close()
+
+	// A first message that is not a hello is data.
+	c5 := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, "hi")
+	readToken(t, c5)
+	if err := c5.ExpectMessages(`{"seq":1,"data":"echo:hi"}`); err != nil {
+		t.Error(err)
+	}
+	c5.Close()
+}
+
+func TestResumerMissedTooMany(t *testing.T) {
+	conns := make(chan *gosockjs.Conn, 10)
+	rs := gosockjs.NewResumer(func(c *gosockjs.Conn) {
+		conns <- c
+		c.ReadMessage()
+	})
+	rs.BufferSize = 2
+	server := gosockjstest.NewServer("/resume", rs.Serve)
+	defer server.Close()
+
+	c1 := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":""}`)
+	token := readToken(t, c1)
+	conn := <-conns
+	conn.Write([]byte("a"))
+	if err := c1.ExpectMessages(`{"seq":1,"data":"a"}`); err != nil {
+		t.Error(err)
+	}
+	c1.Close()
+	time.Sleep(50 * time.Millisecond)
+	// Three more messages, one of which falls out of the buffer.
+	for _, m := range []string{"b", "c", "d"} {
+		conn.Write([]byte(m))
+	}
+
+	c2 := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":"`+token+`","seq":1}`)
+	defer c2.Close()
+	if tok := readToken(t, c2); tok == token {
+		t.Errorf("Resumed a connection that missed too many messages")
+	}
+	<-conns
+}
+
+func TestResumerBinary(t *testing.T) {
+	rs := gosockjs.NewResumer(func(c *gosockjs.Conn) {
+		c.SetBinary(true)
+		c.Write([]byte{0xff, 0, 1})
+		m, err := c.ReadMessage()
+		if err == nil {
+			c.Write(m)
+		}
+	})
+	server := gosockjstest.NewServer("/resume", rs.Serve)
+	defer server.Close()
+
+	c := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":""}`)
+	defer c.Close()
+	readToken(t, c)
+	if err := c.ExpectMessages(`{"seq":1,"data":"/wAB"}`); err != nil {
+		t.Error(err)
+	}
+	c.Send(base64.StdEncoding.EncodeToString([]byte{0, 0xfe}))
+	if err := c.ExpectMessages(`{"seq":2,"data":"AP4="}`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResumerBinarySession(t *testing.T) {
+	rs := gosockjs.NewResumer(func(c *gosockjs.Conn) {
+		m, err := c.ReadMessage()
+		if err == nil {
+			c.Write(m)
+		}
+	})
+	server := gosockjstest.NewServer("/resume", rs.Serve, func(c *gosockjs.Config) {
+		c.BinaryMode = true
+	})
+	defer server.Close()
+
+	// The session encodes whole frames; the handler's messages are text.
+	enc := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	c := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, enc(`{"resume":""}`))
+	defer c.Close()
+	m, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := base64.StdEncoding.DecodeString(m); err != nil || !strings.HasPrefix(string(token), `{"token":`) {
+		t.Fatalf("Expected a token, got %q", m)
+	}
+	c.Send(enc("hello"))
+	if err := c.ExpectMessages(enc(`{"seq":1,"data":"hello"}`)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResumerOverflow(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	rs := gosockjs.NewResumer(func(c *gosockjs.Conn) {
+		// A handler that never reads.
+		<-done
+	})
+	server := gosockjstest.NewServer("/resume", rs.Serve)
+	defer server.Close()
+
+	c := resumeHello(t, server.BaseUrl, gosockjstest.Websocket, `{"resume":""}`)
+	defer c.Close()
+	readToken(t, c)
+	// In batches small enough for the session's own queue.
+	batch := make([]string, 100)
+	for i := range batch {
+		batch[i] = "x"
+	}
+	for i := 0; i < 11; i++ {
+		c.Send(batch...)
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.ExpectClose(3000); err != nil {
+		t.Errorf("Overflowing the queue: %v", err)
+	}
+}
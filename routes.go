@@ -0,0 +1,130 @@
+package gosockjs
+
+import (
+	"net/http"
+	"path"
+)
+
+// Route is one of a Router's endpoints.
+type Route struct {
+	// Path is the path, in gorilla/mux template syntax, including the
+	// router's base url.
+	Path string
+	// Methods are the methods the endpoint answers, or nil for any.
+	Methods []string
+}
+
+type route struct {
+	path    string
+	methods []string
+	handler func(r *Router, w http.ResponseWriter, req *http.Request)
+	// enabled says whether the route's transport is enabled. If it is nil
+	// the route always is.
+	enabled func(c *Config) bool
+}
+
+// sessionPath is the prefix of the session endpoints.
+const sessionPath = "/{serverid:[^./]+}/{sessionid:[^./]+}"
+
+var routes = []route{
+	// Greeting, info
+	{"/", []string{"GET"}, greetingHandler, nil},
+	{"/info", []string{"GET", "OPTIONS"}, (*Router).infoMethod, nil},
+
+	// Iframe
+	{"/iframe.html", []string{"GET"}, iframeHandler, iframeEnabled},
+	{"/iframe-.html", []string{"GET"}, iframeHandler, iframeEnabled},
+	{"/iframe-{ver}.html", []string{"GET"}, iframeHandler, iframeEnabled},
+
+	// Websockets. We don't worry about sessions.
+	{"/websocket", []string{"GET"}, rawWebsocketHandler, rawWebsocketEnabled},
+	{sessionPath + "/websocket", nil, websocketHandler, websocketEnabled},
+
+	// XHR
+	{sessionPath + "/xhr", []string{"POST", "OPTIONS"}, xhrHandler, xhrPollingEnabled},
+	{sessionPath + "/xhr_streaming", []string{"POST", "OPTIONS"}, xhrStreamingHandler, xhrStreamingEnabled},
+	{sessionPath + "/xhr_send", []string{"POST", "OPTIONS"}, xhrSendHandler, xhrSendEnabled},
+
+	// JSONP
+	{sessionPath + "/jsonp", []string{"GET", "OPTIONS"}, jsonpHandler, jsonpEnabled},
+	{sessionPath + "/jsonp_send", []string{"POST", "OPTIONS"}, jsonpSendHandler, jsonpEnabled},
+
+	// Eventsource
+	{sessionPath + "/eventsource", []string{"GET", "OPTIONS"}, eventsourceHandler, eventsourceEnabled},
+
+	// HTML
+	{sessionPath + "/htmlfile", []string{"GET", "OPTIONS"}, htmlfileHandler, htmlfileEnabled},
+}
+
+func iframeEnabled(c *Config) bool       { return c.IframeEnabled }
+func rawWebsocketEnabled(c *Config) bool { return c.RawWebsocketEnabled }
+func websocketEnabled(c *Config) bool    { return c.WebsocketEnabled }
+func xhrPollingEnabled(c *Config) bool   { return c.XhrPollingEnabled }
+func xhrStreamingEnabled(c *Config) bool { return c.XhrStreamingEnabled }
+func jsonpEnabled(c *Config) bool        { return c.JsonpEnabled }
+func eventsourceEnabled(c *Config) bool  { return c.EventsourceEnabled }
+func htmlfileEnabled(c *Config) bool     { return c.HtmlfileEnabled }
+
+// xhrSendEnabled is true if any transport that sends with xhr_send is.
+func xhrSendEnabled(c *Config) bool {
+	return c.XhrPollingEnabled || c.XhrStreamingEnabled || c.EventsourceEnabled || c.HtmlfileEnabled
+}
+
+// handlerFunc returns the route's handler, which 404s when its transport is
+// disabled.
+func (rt route) handlerFunc() func(r *Router, w http.ResponseWriter, req *http.Request) {
+	if rt.enabled == nil {
+		return rt.handler
+	}
+	return func(r *Router, w http.ResponseWriter, req *http.Request) {
+		if !rt.enabled(&r.config) {
+			errStatus(w, http.StatusNotFound)
+			return
+		}
+		rt.handler(r, w, req)
+	}
+}
+
+// Routes returns the router's endpoints, leaving out those of disabled
+// transports. The base url itself, without a trailing slash, is served the
+// greeting too.
+func (r *Router) Routes() []Route {
+	var rs []Route
+	for _, rt := range routes {
+		if rt.enabled != nil && !rt.enabled(&r.config) {
+			continue
+		}
+		rs = append(rs, Route{Path: r.baseUrl + rt.path, Methods: rt.methods})
+	}
+	return rs
+}
+
+// NoRedirect wraps h, typically an http.ServeMux, so that requests for
+// unclean paths, such as ones with "//" or "/../", get a 404 instead of
+// being redirected. SockJS clients do not follow redirects.
+func NoRedirect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != cleanPath(req.URL.Path) {
+			notFoundHandler(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Stolen from http package
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	// path.Clean removes trailing slash except for root;
+	// put the trailing slash back if necessary.
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
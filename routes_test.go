@@ -0,0 +1,107 @@
+package gosockjs_test
+
+import (
+	"code.google.com/p/gorilla/mux"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestRelativeRouting(t *testing.T) {
+	r, err := gosockjs.NewRouter("", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := http.NewServeMux()
+	sm.Handle("/deep/echo", http.StripPrefix("/deep/echo", r))
+	sm.Handle("/deep/echo/", http.StripPrefix("/deep/echo", r))
+	gm := mux.NewRouter()
+	gm.PathPrefix("/other/echo").Handler(http.StripPrefix("/other/echo", r))
+
+	// The gorilla router cleans paths itself, by redirecting.
+	mounts := []struct {
+		h     http.Handler
+		path  string
+		clean bool
+	}{
+		{gosockjs.NoRedirect(sm), "/deep/echo", true},
+		{gm, "/other/echo", false},
+	}
+	for _, m := range mounts {
+		server := httptest.NewServer(m.h)
+		base := server.URL + m.path
+		for _, u := range []string{base, base + "/"} {
+			if code, body := get(t, u); code != http.StatusOK || body != "Welcome to SockJS!\n" {
+				t.Errorf("%s gave %d %q", u, code, body)
+			}
+		}
+		if code, _ := get(t, base+"/info"); code != http.StatusOK {
+			t.Errorf("%s/info gave %d", base, code)
+		}
+		// No redirects.
+		unclean := []string{base + "/info/"}
+		if m.clean {
+			unclean = append(unclean, base+"//info", base+"/a/../info")
+		}
+		for _, u := range unclean {
+			if code, _ := get(t, u); code != http.StatusNotFound {
+				t.Errorf("%s gave %d", u, code)
+			}
+		}
+		for _, transport := range []string{gosockjstest.Websocket, gosockjstest.XhrStreaming} {
+			c, err := gosockjstest.Dial(base, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.ExpectOpen()
+			c.Send("hello")
+			if err := c.ExpectMessages("hello"); err != nil {
+				t.Errorf("%s %s: %v", base, transport, err)
+			}
+			c.Close()
+		}
+		server.Close()
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	r, _ := gosockjs.NewRouter("/echo", echo)
+	found := false
+	for _, rt := range r.Routes() {
+		if rt.Path == "/echo/{serverid:[^./]+}/{sessionid:[^./]+}/xhr_send" {
+			found = len(rt.Methods) == 2 && rt.Methods[0] == "POST"
+		}
+	}
+	if !found {
+		t.Errorf("Routes gave %v", r.Routes())
+	}
+
+	// Disabled transports have no routes.
+	r, _ = gosockjs.NewRouter("/echo", echo, func(c *gosockjs.Config) {
+		c.JsonpEnabled = false
+	})
+	for _, rt := range r.Routes() {
+		if strings.Contains(rt.Path, "jsonp") {
+			t.Errorf("Routes included disabled %s", rt.Path)
+		}
+	}
+	if len(r.Routes()) == 0 {
+		t.Errorf("Routes gave none")
+	}
+}
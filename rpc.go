@@ -0,0 +1,317 @@
+package gosockjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MessageConn is a connection that carries whole messages, like a Conn.
+type MessageConn interface {
+	ReadMessage() ([]byte, error)
+	Write(data []byte) (int, error)
+	Close() error
+}
+
+// Standard error codes, as in JSON-RPC.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	// RPCServerError is the code for errors from methods that are not
+	// *RPCErrors.
+	RPCServerError = -32000
+	// RPCBusy is the code for calls refused because too many are running.
+	RPCBusy = -32001
+)
+
+var RPCTimeout error = errors.New("RPC call timed out.")
+var RPCClosed error = errors.New("RPC connection closed.")
+
+// RPCError is an error object sent in reply to a call.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// RPCMethod is a method that can be called remotely. params is the JSON of
+// the call's params, or nil if it had none. If the error is an *RPCError
+// the caller gets it as is.
+type RPCMethod func(params json.RawMessage) (result interface{}, err error)
+
+// RPCConn makes remote procedure calls in both directions over a connection.
+// Every message is a JSON object. A call is
+//
+//	{"id": id, "method": "name", "params": ...}
+//
+// with params optional, and is answered with {"id": id, "result": ...} or
+// {"id": id, "error": {"code": code, "message": "..."}}. A call without an
+// id is a notification, and gets no answer.
+//
+// A handler registers its methods then calls Serve, which dispatches calls
+// and replies until the connection closes. Calls run concurrently, each in
+// its own goroutine, and may call back to the other side.
+type RPCConn struct {
+	// Timeout is how long Call waits for a reply. Zero means forever.
+	Timeout time.Duration
+	// MaxCalls is how many calls from the other side may run at once. Serve
+	// refuses any more with RPCBusy, and drops notifications. Zero means no
+	// limit.
+	MaxCalls int
+
+	conn    MessageConn
+	methods map[string]RPCMethod
+	pending map[uint64]chan *rpcMessage
+	nextId  uint64
+	closed  bool
+	lock    sync.Mutex
+}
+
+type rpcMessage struct {
+	Id     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+// NewRPCConn wraps c. It is typically a *Conn, but can be anything that
+// carries messages, such as a ReliableConn.
+func NewRPCConn(c MessageConn) *RPCConn {
+	return &RPCConn{
+		Timeout:  30 * time.Second,
+		MaxCalls: 100,
+		conn:     c,
+		methods:  make(map[string]RPCMethod),
+		pending:  make(map[uint64]chan *rpcMessage),
+	}
+}
+
+// Register makes a method available to the other side.
+func (r *RPCConn) Register(method string, f RPCMethod) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.methods[method] = f
+}
+
+func (r *RPCConn) method(name string) RPCMethod {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.methods[name]
+}
+
+func (r *RPCConn) send(m *rpcMessage) error {
+	js, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.Write(js)
+	return err
+}
+
+// Serve reads the connection, running calls and passing on replies, until
+// it closes. Then calls waiting for replies fail with RPCClosed.
+func (r *RPCConn) Serve() error {
+	defer r.shut()
+	var slots chan bool
+	if r.MaxCalls > 0 {
+		slots = make(chan bool, r.MaxCalls)
+	}
+	for {
+		data, err := r.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		m := new(rpcMessage)
+		if err := json.Unmarshal(data, m); err != nil {
+			r.send(&rpcMessage{Id: json.RawMessage("null"), Error: &RPCError{Code: RPCParseError, Message: "Parse error"}})
+			continue
+		}
+		switch {
+		case m.Method == "":
+			r.reply(m)
+		case slots == nil:
+			go r.answer(m)
+		default:
+			// Refuse rather than wait for a slot, as running calls may be
+			// waiting on replies that only we can read.
+			select {
+			case slots <- true:
+				go func() {
+					resp := r.call(m)
+					<-slots
+					if resp != nil {
+						r.send(resp)
+					}
+				}()
+			default:
+				if m.Id != nil {
+					r.send(&rpcMessage{Id: m.Id, Error: &RPCError{Code: RPCBusy, Message: "Too many calls"}})
+				}
+			}
+		}
+	}
+}
+
+// answer runs a call from the other side and sends the reply.
+func (r *RPCConn) answer(m *rpcMessage) {
+	if resp := r.call(m); resp != nil {
+		r.send(resp)
+	}
+}
+
+// callMethod calls f, turning a panic into an internal error so that it
+// does not take down the server.
+func callMethod(name string, f RPCMethod, params json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("RPCConn: method %s panicked: %v", name, p)
+			result = nil
+			err = &RPCError{Code: RPCInternalError, Message: "Internal error"}
+		}
+	}()
+	return f(params)
+}
+
+// call runs a call from the other side, returning the reply, or nil for a
+// notification.
+func (r *RPCConn) call(m *rpcMessage) *rpcMessage {
+	var result interface{}
+	var err error
+	if f := r.method(m.Method); f != nil {
+		result, err = callMethod(m.Method, f, m.Params)
+	} else {
+		err = &RPCError{Code: RPCMethodNotFound, Message: "Method not found: " + m.Method}
+	}
+	if m.Id == nil {
+		// A notification
+		return nil
+	}
+	resp := &rpcMessage{Id: m.Id}
+	if err != nil {
+		rpcErr, ok := err.(*RPCError)
+		if !ok {
+			rpcErr = &RPCError{Code: RPCServerError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else {
+		resp.Result, err = json.Marshal(result)
+		if err != nil {
+			resp.Result = nil
+			resp.Error = &RPCError{Code: RPCInternalError, Message: err.Error()}
+		}
+	}
+	return resp
+}
+
+// reply passes on a reply to one of our calls.
+func (r *RPCConn) reply(m *rpcMessage) {
+	id, err := strconv.ParseUint(string(m.Id), 10, 64)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	ch := r.pending[id]
+	delete(r.pending, id)
+	r.lock.Unlock()
+	if ch != nil {
+		ch <- m
+	}
+}
+
+func (r *RPCConn) shut() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	for id, ch := range r.pending {
+		close(ch)
+		delete(r.pending, id)
+	}
+}
+
+// Call calls a method on the other side and waits for the reply, which it
+// decodes into result unless that is nil. Serve must be running.
+func (r *RPCConn) Call(method string, params interface{}, result interface{}) error {
+	m := &rpcMessage{Method: method}
+	if params != nil {
+		js, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = js
+	}
+	ch := make(chan *rpcMessage, 1)
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return RPCClosed
+	}
+	r.nextId++
+	id := r.nextId
+	r.pending[id] = ch
+	r.lock.Unlock()
+	m.Id = json.RawMessage(strconv.FormatUint(id, 10))
+
+	forget := func() {
+		r.lock.Lock()
+		delete(r.pending, id)
+		r.lock.Unlock()
+	}
+	if err := r.send(m); err != nil {
+		forget()
+		return err
+	}
+	var timeout <-chan time.Time
+	if r.Timeout > 0 {
+		timer := time.NewTimer(r.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var resp *rpcMessage
+	select {
+	case resp = <-ch:
+	case <-timeout:
+		forget()
+		return RPCTimeout
+	}
+	if resp == nil {
+		return RPCClosed
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// Notify calls a method on the other side without waiting for, or getting,
+// a reply.
+func (r *RPCConn) Notify(method string, params interface{}) error {
+	m := &rpcMessage{Method: method}
+	if params != nil {
+		js, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = js
+	}
+	return r.send(m)
+}
+
+// Close closes the connection.
+func (r *RPCConn) Close() error {
+	return r.conn.Close()
+}
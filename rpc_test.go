@@ -0,0 +1,145 @@
+package gosockjs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
+	"testing"
+	"time"
+)
+
+func TestRPC(t *testing.T) {
+	names := make(chan string, 1)
+	server := gosockjstest.NewServer("/rpc", func(c *gosockjs.Conn) {
+		r := gosockjs.NewRPCConn(c)
+		r.Register("add", func(params json.RawMessage) (interface{}, error) {
+			var args []int
+			if err := json.Unmarshal(params, &args); err != nil {
+				return nil, &gosockjs.RPCError{Code: gosockjs.RPCInvalidParams, Message: "Expected numbers"}
+			}
+			sum := 0
+			for _, n := range args {
+				sum += n
+			}
+			return sum, nil
+		})
+		r.Register("fail", func(json.RawMessage) (interface{}, error) {
+			return nil, errors.New("Failed")
+		})
+		r.Register("panic", func(json.RawMessage) (interface{}, error) {
+			panic("Oops")
+		})
+		r.Register("slow", func(json.RawMessage) (interface{}, error) {
+			time.Sleep(200 * time.Millisecond)
+			return nil, nil
+		})
+		// Calling back to the client.
+		r.Register("hello", func(json.RawMessage) (interface{}, error) {
+			var name string
+			err := r.Call("name", nil, &name)
+			names <- name
+			return nil, err
+		})
+		r.Serve()
+	})
+	defer server.Close()
+
+	for _, transport := range []string{gosockjstest.Websocket, gosockjstest.XhrStreaming} {
+		c, err := gosockjstest.Dial(server.BaseUrl, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ExpectOpen()
+		r := gosockjs.NewRPCConn(c.Conn())
+		r.Register("name", func(json.RawMessage) (interface{}, error) {
+			return "Bob", nil
+		})
+		served := make(chan bool)
+		go func() {
+			r.Serve()
+			close(served)
+		}()
+
+		var sum int
+		if err := r.Call("add", []int{1, 2, 3}, &sum); err != nil || sum != 6 {
+			t.Errorf("%s: add gave %d, %v", transport, sum, err)
+		}
+		err = r.Call("add", "x", &sum)
+		if e, ok := err.(*gosockjs.RPCError); !ok || e.Code != gosockjs.RPCInvalidParams {
+			t.Errorf("%s: bad params gave %v", transport, err)
+		}
+		err = r.Call("fail", nil, nil)
+		if e, ok := err.(*gosockjs.RPCError); !ok || e.Code != gosockjs.RPCServerError || e.Message != "Failed" {
+			t.Errorf("%s: fail gave %v", transport, err)
+		}
+		err = r.Call("panic", nil, nil)
+		if e, ok := err.(*gosockjs.RPCError); !ok || e.Code != gosockjs.RPCInternalError {
+			t.Errorf("%s: panic gave %v", transport, err)
+		}
+		err = r.Call("nonesuch", nil, nil)
+		if e, ok := err.(*gosockjs.RPCError); !ok || e.Code != gosockjs.RPCMethodNotFound {
+			t.Errorf("%s: unknown method gave %v", transport, err)
+		}
+		if err := r.Call("hello", nil, nil); err != nil {
+			t.Errorf("%s: hello gave %v", transport, err)
+		}
+		if name := <-names; name != "Bob" {
+			t.Errorf("%s: server got name %q", transport, name)
+		}
+		r.Timeout = 50 * time.Millisecond
+		if err := r.Call("slow", nil, nil); err != gosockjs.RPCTimeout {
+			t.Errorf("%s: slow call gave %v", transport, err)
+		}
+		r.Close()
+		<-served
+		if err := r.Call("add", []int{1}, &sum); err != gosockjs.RPCClosed {
+			t.Errorf("%s: call after close gave %v", transport, err)
+		}
+	}
+}
+
+func TestRPCMaxCalls(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	server := gosockjstest.NewServer("/rpc", func(c *gosockjs.Conn) {
+		r := gosockjs.NewRPCConn(c)
+		r.MaxCalls = 1
+		r.Register("wait", func(json.RawMessage) (interface{}, error) {
+			started <- true
+			<-release
+			return nil, nil
+		})
+		r.Register("add", func(json.RawMessage) (interface{}, error) {
+			return 0, nil
+		})
+		r.Serve()
+	})
+	defer server.Close()
+
+	c, err := gosockjstest.Dial(server.BaseUrl, gosockjstest.Websocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ExpectOpen()
+	r := gosockjs.NewRPCConn(c.Conn())
+	go r.Serve()
+	defer r.Close()
+
+	waited := make(chan error)
+	go func() {
+		waited <- r.Call("wait", nil, nil)
+	}()
+	<-started
+	err = r.Call("add", nil, nil)
+	if e, ok := err.(*gosockjs.RPCError); !ok || e.Code != gosockjs.RPCBusy {
+		t.Errorf("Call over the limit gave %v", err)
+	}
+	close(release)
+	if err := <-waited; err != nil {
+		t.Errorf("wait gave %v", err)
+	}
+	if err := r.Call("add", nil, nil); err != nil {
+		t.Errorf("Call after the limit cleared gave %v", err)
+	}
+}
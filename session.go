@@ -2,6 +2,7 @@ package gosockjs
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,12 @@ import (
 
 var JSONError error = errors.New("Broken JSON encoding.")
 var EmptyPayload error = errors.New("Payload expected.")
+var Base64Error error = errors.New("Broken base64 encoding.")
+var TooManySessions error = errors.New("Too many sessions.")
+
+// CloseServerBusy is the close code for sessions refused because the
+// router's session limits have been reached.
+const CloseServerBusy = 3503
 
 type message string
 
@@ -44,6 +51,8 @@ type session struct {
 	// Reading, client -> server
 	readQueue chan message
 	unread    []byte
+	// done is closed when the session closes, to end reads.
+	done chan struct{}
 
 	// Heartbeat and disconnect timers
 	timerLock sync.Mutex
@@ -52,14 +61,24 @@ type session struct {
 	// Writing
 	outbox []message
 
+	// conn is the session's one Conn, which its handler and interceptors get.
+	conn        *Conn
 	router      *Router
 	sessionId   string
+	remote      string
 	trans       transport
 	readLock    sync.Mutex
 	writeLock   sync.Mutex
 	sessionLock sync.Mutex
 
-	closed bool
+	closed      bool
+	closeCode   int
+	closeReason string
+	binaryMode  bool
+
+	// Rate limits on inbound traffic
+	msgBucket  *tokenBucket
+	byteBucket *tokenBucket
 }
 
 // session is an io.ReadWriteCloser
@@ -81,7 +100,7 @@ func (s *session) Read(data []byte) (int, error) {
 		}
 	}
 
-	m, ok := <-s.readQueue
+	m, ok := s.nextMessage()
 	if !ok {
 		// We're closed
 		return 0, io.EOF
@@ -96,36 +115,123 @@ func (s *session) Read(data []byte) (int, error) {
 	return nm, nil
 }
 
+func (s *session) readMessage() ([]byte, error) {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	if s.unread != nil {
+		m := s.unread
+		s.unread = nil
+		return m, nil
+	}
+	m, ok := s.nextMessage()
+	if !ok {
+		return nil, io.EOF
+	}
+	return m.bytes(), nil
+}
+
+// nextMessage waits for a message from the client. Once the session is
+// closed it returns the messages still queued, then false.
+func (s *session) nextMessage() (message, bool) {
+	select {
+	case m := <-s.readQueue:
+		return m, true
+	case <-s.done:
+		select {
+		case m := <-s.readQueue:
+			return m, true
+		default:
+			return "", false
+		}
+	}
+}
+
 func (s *session) Write(data []byte) (int, error) {
+	n := len(data)
+	if chain := s.router.config.OutboundInterceptors; len(chain) > 0 {
+		var err error
+		if data, err = s.intercept(chain, data); err != nil {
+			return 0, err
+		}
+		if data == nil {
+			// Dropped
+			return n, nil
+		}
+	}
+	// Sending can block up to the WriteTimeout, so it is done without the
+	// session lock.
 	s.sessionLock.Lock()
-	defer s.sessionLock.Unlock()
-	if s.closed {
+	closed, binary := s.closed, s.binaryMode
+	s.sessionLock.Unlock()
+	if closed {
 		return 0, io.EOF
 	}
-	err := s.fromServer(message(data))
+	m := message(data)
+	if binary {
+		m = message(base64.StdEncoding.EncodeToString(data))
+	}
+	err := s.fromServer(m)
 	if err != nil {
 		// Assume nothing was written
 		return 0, err
 	}
-	return len(data), nil
+	return n, nil
 }
 
 func (s *session) Close() error {
+	return s.closeWith(3000, "Go away!")
+}
+
+// closeWith closes the session, telling the client why.
+func (s *session) closeWith(code int, reason string) error {
 	s.sessionLock.Lock()
 	defer s.sessionLock.Unlock()
 	if !s.closed {
 		s.closed = true
+		s.closeCode = code
+		s.closeReason = reason
 		// Tell any waiting receiver
-		s.trans.sendFrame(closeFrame(3000, "Go away!"))
+		s.trans.sendFrame(s.closingFrame())
 		s.trans.closeTransport()
-		setTimer(s, nil)
+		// The closed session answers receivers until it is removed.
+		setDisconnect(s)
+		close(s.done)
 	}
 	return nil
 }
 
-func newSession(r *Router) *session {
-	s := &session{router: r}
+func (s *session) isClosed() bool {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	return s.closed
+}
+
+// closingFrame is the frame sent to receivers of a closed session.
+func (s *session) closingFrame() []byte {
+	return closeFrame(s.closeCode, s.closeReason)
+}
+
+func (s *session) setBinary(binary bool) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	s.binaryMode = binary
+}
+
+func (s *session) binary() bool {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	return s.binaryMode
+}
+
+// newSession starts the session's disconnect timer, so everything the timer
+// reads is set here.
+func newSession(r *Router, sessionId, remote string) *session {
+	s := &session{router: r, sessionId: sessionId, remote: remote, binaryMode: r.config.BinaryMode}
+	s.msgBucket = newTokenBucket(r.config.MessageRateLimit)
+	s.byteBucket = newTokenBucket(r.config.ByteRateLimit)
 	s.readQueue = make(chan message, 1024)
+	s.done = make(chan struct{})
+	s.conn = &Conn{s}
 	setDisconnect(s)
 	return s
 }
@@ -140,8 +246,10 @@ func (s *session) fromClient(m message) error {
 		return nil
 	}
 	var strings []string
-	// Hacky, but easy
-	if b[0] == '[' {
+	var msgs []message
+	var nbytes int
+	// Hacky, but easy. JSON may start with whitespace.
+	if t := bytes.TrimLeft(b, " \t\r\n"); len(t) > 0 && t[0] == '[' {
 		// An array
 		err := json.Unmarshal(b, &strings)
 		if err != nil {
@@ -155,9 +263,41 @@ func (s *session) fromClient(m message) error {
 		}
 		strings = append(strings, str)
 	}
+	binary := s.binary()
 	for _, str := range strings {
+		m := message(str)
+		if binary {
+			// Decode everything before queueing anything.
+			data, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return Base64Error
+			}
+			m = message(data)
+		}
+		msgs = append(msgs, m)
+		nbytes += len(m)
+	}
+	if err := s.checkRate(len(msgs), nbytes); err != nil {
+		return err
+	}
+	if chain := s.router.config.InboundInterceptors; len(chain) > 0 {
+		// Intercept the whole batch before queueing any of it, so a batch
+		// that closes the session is not partly delivered.
+		var kept []message
+		for _, m := range msgs {
+			b, err := s.intercept(chain, m.bytes())
+			if err != nil {
+				return err
+			}
+			if b != nil {
+				kept = append(kept, message(b))
+			}
+		}
+		msgs = kept
+	}
+	for _, m := range msgs {
 		select {
-		case s.readQueue <- message(str):
+		case s.readQueue <- m:
 		default:
 			return errors.New("Message queue full")
 		}
@@ -202,16 +342,21 @@ func setTimer(s *session, t *time.Timer) {
 }
 
 func heartbeatFunc(s *session) {
+	if s.isClosed() {
+		// Close raced us; don't stop its disconnect timer.
+		setDisconnect(s)
+		return
+	}
 	s.trans.sendFrame(heartbeatFrame())
 	setHeartbeat(s)
 }
 
 func setHeartbeat(s *session) {
-	setTimer(s, time.AfterFunc(s.router.HeartbeatDelay, func() { heartbeatFunc(s) }))
+	setTimer(s, time.AfterFunc(s.router.config.HeartbeatDelay, func() { heartbeatFunc(s) }))
 }
 
 func setDisconnect(s *session) {
-	setTimer(s, time.AfterFunc(s.router.DisconnectDelay, func() {
+	setTimer(s, time.AfterFunc(s.router.config.DisconnectDelay, func() {
 		s.router.removeSession(s.sessionId, s)
 		s.Close()
 	}))
@@ -219,8 +364,8 @@ func setDisconnect(s *session) {
 
 // Events from the transport.
 func (s *session) newReceiver() {
-	if s.closed {
-		s.trans.sendFrame(closeFrame(3000, "Go away!"))
+	if s.isClosed() {
+		s.trans.sendFrame(s.closingFrame())
 		return
 	}
 	s.tryToFlush()
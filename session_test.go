@@ -2,8 +2,10 @@ package gosockjs
 
 import (
 	"bytes"
+	"io"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestFrames(t *testing.T) {
@@ -42,3 +44,60 @@ func TestFrames(t *testing.T) {
 		t.Errorf("message frame was\n%s, not\n%s", a, buf.Bytes())
 	}
 }
+
+// A transport that just remembers the frames it was sent.
+type recordingTransport struct {
+	frames [][]byte
+}
+
+func (t *recordingTransport) writeFrame(w io.Writer, frame []byte) error {
+	_, err := w.Write(frame)
+	return err
+}
+
+func (t *recordingTransport) sendFrame(frame []byte) error {
+	t.frames = append(t.frames, frame)
+	return nil
+}
+
+func (t *recordingTransport) closeTransport() {
+}
+
+func TestBinaryMode(t *testing.T) {
+	r := &Router{config: Config{DisconnectDelay: time.Second, BinaryMode: true}}
+	s := newSession(r, "", "")
+	defer s.Close()
+	trans := new(recordingTransport)
+	s.trans = trans
+
+	data := []byte{0x00, 0xff, 0xfe, 'a', 0x80}
+	conn := &Conn{s}
+	if !conn.Binary() {
+		t.Errorf("Session did not start in binary mode")
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Write returned error %v", err)
+	}
+	expected := `a["AP/+YYA="]`
+	if len(trans.frames) != 1 || string(trans.frames[0]) != expected {
+		t.Errorf("Frames were %q, not %q", trans.frames, expected)
+	}
+
+	if err := s.fromClient(message(`["AP/+YYA="]`)); err != nil {
+		t.Fatalf("fromClient returned error %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], data) {
+		t.Errorf("Read %v with error %v, not %v", buf[:n], err, data)
+	}
+	if err := s.fromClient(message(`"not base64!"`)); err != Base64Error {
+		t.Errorf("Bad base64 gave error %v", err)
+	}
+
+	conn.SetBinary(false)
+	conn.Write([]byte("abc"))
+	if string(trans.frames[1]) != `a["abc"]` {
+		t.Errorf("Text mode frame was %s", trans.frames[1])
+	}
+}
@@ -0,0 +1,29 @@
+package gosockjs
+
+// Stats are counts of what a Router has done.
+type Stats struct {
+	// Rotations is the number of streaming responses closed at their
+	// StreamLimit, by transport: "xhr_streaming", "eventsource" and
+	// "htmlfile".
+	Rotations map[string]uint64
+}
+
+// Stats returns the router's counts so far.
+func (r *Router) Stats() Stats {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	st := Stats{Rotations: make(map[string]uint64)}
+	for t, n := range r.rotations {
+		st.Rotations[t] = n
+	}
+	return st
+}
+
+func (r *Router) countRotation(transport string) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	if r.rotations == nil {
+		r.rotations = make(map[string]uint64)
+	}
+	r.rotations[transport]++
+}
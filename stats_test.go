@@ -0,0 +1,70 @@
+package gosockjs
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openXhrStreaming(t *testing.T, u string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("POST", u+"/xhr_streaming", nil)
+	req.Close = true
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(r.Body)
+	// The prelude and the open frame
+	br.ReadString('\n')
+	if line, _ := br.ReadString('\n'); line != "o\n" {
+		t.Errorf("Open frame was %q", line)
+	}
+	return r, br
+}
+
+func TestStreamLimits(t *testing.T) {
+	big := strings.Repeat("x", 5000)
+	frame := `a["` + big + `"]` + "\n"
+
+	// The default rotates after 4096 bytes.
+	server, baseUrl := startEchoServer()
+	r, br := openXhrStreaming(t, baseUrl+"/000/a")
+	xhrSend(t, baseUrl+"/000/a", `["`+big+`"]`)
+	if rest, _ := ioutil.ReadAll(br); string(rest) != frame {
+		t.Errorf("Got %d bytes before rotating", len(rest))
+	}
+	r.Body.Close()
+	if n := server.Router.Stats().Rotations["xhr_streaming"]; n != 1 {
+		t.Errorf("Counted %d xhr_streaming rotations", n)
+	}
+	server.Close()
+
+	server, baseUrl = startEchoServer(func(c *Config) {
+		c.XhrStreamingLimit = StreamLimit{MaxBytes: 1 << 20}
+		c.EventsourceLimit = StreamLimit{MaxDuration: 50 * time.Millisecond}
+	})
+	defer server.Close()
+	r, br = openXhrStreaming(t, baseUrl+"/000/b")
+	defer r.Body.Close()
+	for i := 0; i < 2; i++ {
+		xhrSend(t, baseUrl+"/000/b", `["`+big+`"]`)
+		if line, err := br.ReadString('\n'); line != frame {
+			t.Errorf("Message %d was %d bytes, %v", i, len(line), err)
+		}
+	}
+
+	start := time.Now()
+	r, _ = openEventsource(t, baseUrl+"/000/c", "")
+	ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("Eventsource response lasted %v", d)
+	}
+	st := server.Router.Stats()
+	if st.Rotations["eventsource"] != 1 || st.Rotations["xhr_streaming"] != 0 {
+		t.Errorf("Counted rotations %v", st.Rotations)
+	}
+}
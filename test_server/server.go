@@ -1,12 +1,27 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/mrlauer/gosockjs"
+	"github.com/mrlauer/gosockjs/gosockjstest"
 	"io"
 	"log"
 	"net/http"
-	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	port             = flag.Int("port", 8081, "port to listen on")
+	host             = flag.String("host", "127.0.0.1", "address to listen on")
+	heartbeatDelay   = flag.Duration("heartbeat", 25*time.Second, "heartbeat delay")
+	disconnectDelay  = flag.Duration("disconnect", 5*time.Second, "disconnect delay")
+	websocket        = flag.Bool("websocket", true, "enable websockets, except on /disabled_websocket_echo")
+	cookieNeeded     = flag.Bool("cookie_needed", false, "set the JSESSIONID cookie on every endpoint, not just /cookie_needed_echo")
+	maxAmplify       = flag.Int("max_amplify", gosockjstest.MaxAmplify, "largest power of 2 /amplify will send")
+	eventsourceRetry = flag.Duration("eventsource_retry", 0, "eventsource reconnection delay; nonzero adds event ids and resumption")
 )
 
 func echo(c *gosockjs.Conn) {
@@ -17,49 +32,117 @@ func closeSock(c *gosockjs.Conn) {
 	c.Close()
 }
 
-type NoRedirectServer struct {
-	*http.ServeMux
+// endpointOptions holds the -set flags, which change one setting of one
+// endpoint, by endpoint.
+type endpointOptions map[string][]gosockjs.Option
+
+func (o endpointOptions) String() string {
+	return ""
 }
 
-// Stolen from http package
-func cleanPath(p string) string {
-	if p == "" {
-		return "/"
+func (o endpointOptions) Set(flag string) error {
+	i, j := strings.Index(flag, ":"), strings.Index(flag, "=")
+	if i < 0 || j < i {
+		return fmt.Errorf("%q is not endpoint:setting=value", flag)
 	}
-	if p[0] != '/' {
-		p = "/" + p
+	endpoint, name, value := flag[:i], flag[i+1:j], flag[j+1:]
+	setting := settings[name]
+	if setting == nil {
+		return fmt.Errorf("unknown setting %q", name)
 	}
-	np := path.Clean(p)
-	// path.Clean removes trailing slash except for root;
-	// put the trailing slash back if necessary.
-	if p[len(p)-1] == '/' && np != "/" {
-		np += "/"
+	opt, err := setting(value)
+	if err != nil {
+		return err
 	}
-	return np
+	o[endpoint] = append(o[endpoint], opt)
+	return nil
 }
 
-func (m *NoRedirectServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// To get the sockjs-protocol tests to work, barf if the path is not already clean.
-	if req.URL.Path != cleanPath(req.URL.Path) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		return
-	}
-	http.DefaultServeMux.ServeHTTP(w, req)
+// The settings -set can change.
+var settings = map[string]func(value string) (gosockjs.Option, error){
+	"websocket": func(value string) (gosockjs.Option, error) {
+		v, err := strconv.ParseBool(value)
+		return func(c *gosockjs.Config) {
+			c.WebsocketEnabled = v
+			c.RawWebsocketEnabled = v
+		}, err
+	},
+	"cookie_needed": func(value string) (gosockjs.Option, error) {
+		v, err := strconv.ParseBool(value)
+		return func(c *gosockjs.Config) { c.CookieNeeded = v }, err
+	},
+	"heartbeat": func(value string) (gosockjs.Option, error) {
+		v, err := time.ParseDuration(value)
+		return func(c *gosockjs.Config) { c.HeartbeatDelay = v }, err
+	},
+	"disconnect": func(value string) (gosockjs.Option, error) {
+		v, err := time.ParseDuration(value)
+		return func(c *gosockjs.Config) { c.DisconnectDelay = v }, err
+	},
+	"eventsource_retry": func(value string) (gosockjs.Option, error) {
+		v, err := time.ParseDuration(value)
+		return func(c *gosockjs.Config) { c.EventsourceRetry = v }, err
+	},
+	"response_limit": func(value string) (gosockjs.Option, error) {
+		v, err := strconv.Atoi(value)
+		return func(c *gosockjs.Config) {
+			c.XhrStreamingLimit.MaxBytes = v
+			c.EventsourceLimit.MaxBytes = v
+			c.HtmlfileLimit.MaxBytes = v
+		}, err
+	},
 }
 
-func main() {
-	gosockjs.Install("/echo", echo)
-	dwe, err := gosockjs.Install("/disabled_websocket_echo", echo)
-	if err != nil {
-		log.Println(err)
+var perEndpoint = make(endpointOptions)
+
+func init() {
+	flag.Var(perEndpoint, "set", "change a setting of one endpoint, as endpoint:setting=value, e.g. /echo:heartbeat=1s; "+
+		"the settings are websocket, cookie_needed, heartbeat, disconnect, eventsource_retry and response_limit")
+}
+
+// install installs a handler with the options from the command line, then
+// any others, then those set for its endpoint.
+func install(baseUrl string, h gosockjs.Handler, opts ...gosockjs.Option) *gosockjs.Router {
+	flags := func(c *gosockjs.Config) {
+		c.HeartbeatDelay = *heartbeatDelay
+		c.DisconnectDelay = *disconnectDelay
+		c.WebsocketEnabled = *websocket
+		c.RawWebsocketEnabled = *websocket
+		c.CookieNeeded = *cookieNeeded
+		c.EventsourceRetry = *eventsourceRetry
 	}
-	dwe.WebsocketEnabled = false
-	cne, err := gosockjs.Install("/cookie_needed_echo", echo)
+	opts = append([]gosockjs.Option{flags}, opts...)
+	r, err := gosockjs.Install(baseUrl, h, append(opts, perEndpoint[baseUrl]...)...)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
+	}
+	installed[baseUrl] = true
+	return r
+}
+
+// installed holds the base urls installed.
+var installed = make(map[string]bool)
+
+func main() {
+	flag.Parse()
+	install("/echo", echo)
+	install("/disabled_websocket_echo", echo, func(c *gosockjs.Config) {
+		c.WebsocketEnabled = false
+		c.RawWebsocketEnabled = false
+	})
+	install("/cookie_needed_echo", echo, func(c *gosockjs.Config) {
+		c.CookieNeeded = true
+	})
+	install("/close", closeSock)
+	install("/amplify", gosockjstest.Amplify(*maxAmplify))
+	install("/broadcast", gosockjstest.NewBroadcaster().Handle)
+	for endpoint := range perEndpoint {
+		if !installed[endpoint] {
+			log.Fatalf("-set given for unknown endpoint %s", endpoint)
+		}
 	}
-	cne.CookieNeeded = true
-	gosockjs.Install("/close", closeSock)
-	fmt.Println("Listening on port 8081")
-	http.ListenAndServe("127.0.0.1:8081", new(NoRedirectServer))
+	addr := fmt.Sprintf("%s:%d", *host, *port)
+	fmt.Println("Listening on", addr)
+	// To get the sockjs-protocol tests to work, barf if the path is not already clean.
+	log.Fatal(http.ListenAndServe(addr, gosockjs.NoRedirect(http.DefaultServeMux)))
 }
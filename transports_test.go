@@ -0,0 +1,62 @@
+package gosockjs
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDisabledTransports(t *testing.T) {
+	server, baseUrl := startEchoServer()
+	r, err := http.Get(baseUrl + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := bodyJSONMap(r)
+	r.Body.Close()
+	if _, ok := info["transports"]; ok {
+		t.Errorf("Info listed transports unasked: %v", info)
+	}
+	server.Close()
+
+	server, baseUrl = startEchoServer(func(c *Config) {
+		c.JsonpEnabled = false
+		c.HtmlfileEnabled = false
+		c.InfoTransports = true
+	})
+	defer server.Close()
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/000/a/jsonp?c=x", http.StatusNotFound},
+		{"POST", "/000/a/jsonp_send", http.StatusNotFound},
+		{"GET", "/000/a/htmlfile?c=x", http.StatusNotFound},
+		{"POST", "/000/b/xhr", http.StatusOK},
+		{"POST", "/000/b/xhr_send", http.StatusInternalServerError},
+		{"GET", "/iframe.html", http.StatusOK},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, baseUrl+test.path, nil)
+		req.Close = true
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		if r.StatusCode != test.status {
+			t.Errorf("%s %s gave %d, not %d", test.method, test.path, r.StatusCode, test.status)
+		}
+	}
+
+	r, err = http.Get(baseUrl + "/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ = bodyJSONMap(r)
+	r.Body.Close()
+	expected := []interface{}{"websocket", "xhr-streaming", "eventsource", "iframe-eventsource", "xhr-polling", "iframe-xhr-polling"}
+	if !reflect.DeepEqual(info["transports"], expected) {
+		t.Errorf("Info listed transports %v", info["transports"])
+	}
+}
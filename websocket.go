@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 )
 
 func errStatus(w http.ResponseWriter, s int) {
@@ -16,43 +17,126 @@ func errStatus(w http.ResponseWriter, s int) {
 
 // Raw websockets -- no framing
 type rawWebsocketConn struct {
-	ws *websocket.Conn
+	ws      *websocket.Conn
+	proto   string
+	timeout time.Duration
+	lock    sync.Mutex
 }
 
 func (c *rawWebsocketConn) Read(data []byte) (int, error) {
 	return c.ws.Read(data)
 }
 
+func (c *rawWebsocketConn) readMessage() ([]byte, error) {
+	var data []byte
+	err := websocket.Message.Receive(c.ws, &data)
+	return data, err
+}
+
 func (c *rawWebsocketConn) Write(data []byte) (int, error) {
-	return c.ws.Write(data)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n, err := writeWithTimeout(c.ws, c.timeout, data)
+	if err != nil {
+		c.ws.Close()
+	}
+	return n, err
+}
+
+// writeWithTimeout writes data to ws, failing if that takes longer than
+// timeout, unless it is zero.
+func writeWithTimeout(ws *websocket.Conn, timeout time.Duration, data []byte) (int, error) {
+	if timeout > 0 {
+		ws.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return ws.Write(data)
+}
+
+func (c *rawWebsocketConn) setBinary(binary bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if binary {
+		c.ws.PayloadType = websocket.BinaryFrame
+	} else {
+		c.ws.PayloadType = websocket.TextFrame
+	}
+}
+
+func (c *rawWebsocketConn) binary() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ws.PayloadType == websocket.BinaryFrame
+}
+
+func (c *rawWebsocketConn) protocol() string {
+	return c.proto
 }
 
 func (c *rawWebsocketConn) Close() error {
 	return c.ws.Close()
 }
 
-func (r *Router) makeRawWSHandler() websocket.Handler {
+// Check the origin the way websocket.Handler does, and add the session cookie.
+func (r *Router) wsHandshake(config *websocket.Config, req *http.Request) error {
+	var err error
+	config.Origin, err = websocket.Origin(config, req)
+	if err == nil && config.Origin == nil {
+		return errors.New("null origin")
+	}
+	if err != nil {
+		return err
+	}
+	config.Header = r.sessionCookieHeader(req)
+	return nil
+}
+
+// As wsHandshake, and pick the first of our sub-protocols that the client
+// offered. If there is none we answer without one and let the client decide
+// whether to carry on.
+func (r *Router) rawWSHandshake(config *websocket.Config, req *http.Request) error {
+	if err := r.wsHandshake(config, req); err != nil {
+		return err
+	}
+	offered := config.Protocol
+	config.Protocol = nil
+	for _, p := range r.config.RawProtocols {
+		for _, o := range offered {
+			if p == o {
+				config.Protocol = []string{p}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Router) makeRawWSHandler() websocket.Server {
 	h := func(c *websocket.Conn) {
-		rcimpl := &rawWebsocketConn{ws: c}
+		rcimpl := &rawWebsocketConn{ws: c, timeout: r.config.WriteTimeout}
+		if len(c.Config().Protocol) == 1 {
+			rcimpl.proto = c.Config().Protocol[0]
+		}
+		rcimpl.setBinary(r.config.BinaryMode)
 		conn := &Conn{rcimpl}
-		r.handler(conn)
+		handler := r.config.RawHandler
+		if handler == nil {
+			handler = r.handler
+		}
+		handler(conn)
 	}
-	return websocket.Handler(h)
+	return websocket.Server{Handler: h, Handshake: r.rawWSHandshake}
 }
 
 func rawWebsocketHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	if !r.WebsocketEnabled {
-		errStatus(w, http.StatusNotFound)
-		return
-	}
 	h := r.makeRawWSHandler()
 	h.ServeHTTP(w, req)
 }
 
 // (Non-raw) websockets; with framing
 type wsTransport struct {
-	lock sync.RWMutex
-	ws   *websocket.Conn
+	lock    sync.RWMutex
+	ws      *websocket.Conn
+	timeout time.Duration
 }
 
 func (t *wsTransport) conn() *websocket.Conn {
@@ -74,10 +158,15 @@ func (t *wsTransport) writeFrame(w io.Writer, frame []byte) error {
 
 func (t *wsTransport) sendFrame(frame []byte) error {
 	ws := t.conn()
-	if ws != nil {
-		return t.writeFrame(ws, frame)
+	if ws == nil {
+		return errors.New("No connection")
+	}
+	if _, err := writeWithTimeout(ws, t.timeout, frame); err != nil {
+		// A client that stopped reading; the session ends with it.
+		t.closeTransport()
+		return err
 	}
-	return errors.New("No connection")
+	return nil
 }
 
 func (t *wsTransport) closeTransport() {
@@ -88,21 +177,48 @@ func (t *wsTransport) closeTransport() {
 	}
 }
 
-func (r *Router) makeWSHandler() websocket.Handler {
+// Websocket sessions are not kept in the session map, but they count
+// against the limits all the same.
+func (r *Router) countWSSession(remote string) error {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	return r.countNewSession(remote)
+}
+
+func (r *Router) uncountWSSession(remote string) {
+	r.sessionLock.Lock()
+	defer r.sessionLock.Unlock()
+	r.uncountSession(remote)
+}
+
+func (r *Router) makeWSHandler() websocket.Server {
 	h := func(c *websocket.Conn) {
-		s := newSession(r)
-		trans := &wsTransport{ws: c}
+		remote := r.remoteHost(c.Request())
+		err := r.countWSSession(remote)
+		if err != nil {
+			c.Write(openFrame())
+			c.Write(refusalFrame(err))
+			c.Close()
+			return
+		}
+		s := newSession(r, "", remote)
+		trans := &wsTransport{ws: c, timeout: r.config.WriteTimeout}
 		s.trans = trans
 		s.newReceiver()
 		s.trans.sendFrame(openFrame())
 		// Read from the websocket in a goroutine.
 		go func() {
+			defer r.uncountWSSession(remote)
 			for {
 				var m string
 				err := websocket.Message.Receive(c, &m)
 				if err == nil {
 					err = s.fromClient(message(m))
 				}
+				if (err == RateLimited || err == BatchTooLarge) && r.config.RateLimitPolicy != RateLimitClose {
+					// Drop the message, keep the session.
+					continue
+				}
 				if err != nil {
 					trans.closeTransport()
 					s.Close()
@@ -111,8 +227,7 @@ func (r *Router) makeWSHandler() websocket.Handler {
 			}
 		}()
 		// And run the handler
-		conn := &Conn{s}
-		r.handler(conn)
+		r.handler(s.conn)
 		/*
 			rcimpl := &websocketConn{ws: c}
 			conn := &Conn{rcimpl}
@@ -120,14 +235,10 @@ func (r *Router) makeWSHandler() websocket.Handler {
 			r.handler(conn)
 		*/
 	}
-	return websocket.Handler(h)
+	return websocket.Server{Handler: h, Handshake: r.wsHandshake}
 }
 
 func websocketHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	if !r.WebsocketEnabled {
-		errStatus(w, http.StatusNotFound)
-		return
-	}
 	// Some checks
 	if req.Method != "GET" {
 		// This is gross. To avoid putting extra headers in, we'll hijack the connection!
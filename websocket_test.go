@@ -0,0 +1,97 @@
+package gosockjs
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func wsUrl(httpUrl string) string {
+	return "ws" + strings.TrimPrefix(httpUrl, "http")
+}
+
+func TestRawWebsocketHandler(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.RawProtocols = []string{"bar", "baz"}
+	}, WithRawHandler(func(c *Conn) {
+		io.WriteString(c, "raw:"+c.Protocol())
+		c.Close()
+	}))
+	defer server.Close()
+
+	config, err := websocket.NewConfig(wsUrl(baseUrl)+"/websocket", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Protocol = []string{"foo", "baz", "bar"}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("Could not dial: %v", err)
+	}
+	defer ws.Close()
+	var m string
+	if err := websocket.Message.Receive(ws, &m); err != nil || m != "raw:bar" {
+		t.Errorf("Received %q with error %v", m, err)
+	}
+}
+
+func TestRawWebsocketDisabled(t *testing.T) {
+	server, baseUrl := startEchoServer(func(c *Config) { c.RawWebsocketEnabled = false })
+	defer server.Close()
+	r, err := http.Get(baseUrl + "/websocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Errorf("Disabled raw websocket returned %d", r.StatusCode)
+	}
+}
+
+func TestRawWebsocketWithoutWebsocket(t *testing.T) {
+	// The raw endpoint does not depend on the websocket transport.
+	server, baseUrl := startEchoServer(func(c *Config) { c.WebsocketEnabled = false })
+	defer server.Close()
+	ws, err := websocket.Dial(wsUrl(baseUrl)+"/websocket", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Could not dial: %v", err)
+	}
+	defer ws.Close()
+	if err := websocket.Message.Send(ws, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	var m string
+	if err := websocket.Message.Receive(ws, &m); err != nil || m != "abc" {
+		t.Errorf("Received %q with error %v", m, err)
+	}
+}
+
+func TestRawWebsocketWriteTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.WriteTimeout = 500 * time.Millisecond
+	}, WithRawHandler(func(c *Conn) {
+		// More than the connection can buffer.
+		_, err := c.Write(make([]byte, 32<<20))
+		errs <- err
+	}))
+	defer server.Close()
+
+	// A client that never reads.
+	ws, err := websocket.Dial(wsUrl(baseUrl)+"/websocket", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Could not dial: %v", err)
+	}
+	defer ws.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("Write to a stalled client succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Write to a stalled client blocked")
+	}
+}
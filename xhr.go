@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 func xhrProlog(w http.ResponseWriter, req *http.Request) bool {
@@ -31,6 +32,8 @@ type xhrTransport struct {
 	s        *session
 	opts     xhrOptions
 	lock     sync.RWMutex
+	// events numbers the frames of resumable eventsource sessions.
+	events *eventHistory
 }
 
 func (t *xhrTransport) writeFrame(w io.Writer, frame []byte) error {
@@ -41,6 +44,9 @@ func (t *xhrTransport) sendFrame(frame []byte) error {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 	if t.receiver != nil {
+		if t.events != nil {
+			return t.events.send(t.receiver, frame)
+		}
 		return t.receiver.opts.writeFrame(t.receiver, frame)
 	}
 	return errors.New("No receiver")
@@ -54,13 +60,24 @@ func (t *xhrTransport) closeTransport() {
 	}
 }
 
-func (t *xhrTransport) setReceiver(r *xhrReceiver) error {
+// setReceiver makes r the receiver. lastEventId is the Last-Event-ID of an
+// eventsource receiver.
+func (t *xhrTransport) setReceiver(r *xhrReceiver, lastEventId string) error {
 	t.lock.Lock()
 	if t.receiver != nil {
-		defer t.lock.Unlock()
-		// Nyet.
-		t.writeFrame(r, closeFrame(2010, "Another connection still open"))
-		return errors.New("Another connection still open")
+		if t.events == nil {
+			defer t.lock.Unlock()
+			// Nyet.
+			t.writeFrame(r, closeFrame(2010, "Another connection still open"))
+			return errors.New("Another connection still open")
+		}
+		// An eventsource client reconnecting before we noticed its old
+		// connection go.
+		t.receiver.Close()
+	}
+	if t.events != nil {
+		// Catch up a receiver that lost its connection.
+		t.events.replay(r, lastEventId)
 	}
 	t.receiver = r
 	r.t = t
@@ -69,10 +86,14 @@ func (t *xhrTransport) setReceiver(r *xhrReceiver) error {
 	return nil
 }
 
-func (t *xhrTransport) clearReceiver() {
+// clearReceiver forgets r, if it is still the receiver.
+func (t *xhrTransport) clearReceiver(r *xhrReceiver) {
 	t.lock.Lock()
-	t.receiver = nil
 	defer t.lock.Unlock()
+	if t.receiver != r {
+		return
+	}
+	t.receiver = nil
 	t.s.disconnectReceiver()
 }
 
@@ -96,12 +117,35 @@ func (r *xhrReceiver) Write(data []byte) (int, error) {
 	}
 	n, err := r.w.Write(data)
 	r.nwritten += n
-	if r.nwritten > r.opts.maxBytes() {
+	if err != nil {
+		// The client has stopped reading, or gone. Drop the connection; the
+		// frame being sent stays in the session's outbox for the next one.
 		r.internalClose()
+		return n, err
+	}
+	if max := r.opts.maxBytes(); max > 0 && r.nwritten > max {
+		r.internalRotate()
 	}
 	return n, err
 }
 
+// rotate closes a receiver that has reached its limit, so the client opens
+// another.
+func (r *xhrReceiver) rotate() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.internalRotate()
+}
+
+func (r *xhrReceiver) internalRotate() {
+	if r.internalClose() != nil {
+		return
+	}
+	if so, ok := r.opts.(streamOptions); ok {
+		r.t.s.router.countRotation(so.transport())
+	}
+}
+
 func (r *xhrReceiver) Close() error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -119,6 +163,8 @@ func (r *xhrReceiver) internalClose() error {
 
 // differentiate between XhrPolling and XhrStreaming
 type xhrOptions interface {
+	// maxBytes is how much the receiver writes before closing. Zero means
+	// no limit.
 	maxBytes() int
 	writeFrame(w io.Writer, frame []byte) error
 	writePrelude(w io.Writer) error
@@ -156,10 +202,33 @@ func (o xhrPollingOptions) streaming() bool {
 
 type xhrStreamingOptions struct {
 	xhrBaseOptions
+	streamLimit
+}
+
+// streamOptions is implemented by the options of streaming transports,
+// whose receivers are rotated at a StreamLimit.
+type streamOptions interface {
+	xhrOptions
+	maxDuration() time.Duration
+	transport() string
+}
+
+// streamLimit is embedded in the options of streaming transports.
+type streamLimit struct {
+	name  string
+	limit StreamLimit
+}
+
+func (o streamLimit) maxBytes() int {
+	return o.limit.MaxBytes
 }
 
-func (o xhrStreamingOptions) maxBytes() int {
-	return 4096 // r.maxBytes
+func (o streamLimit) maxDuration() time.Duration {
+	return o.limit.MaxDuration
+}
+
+func (o streamLimit) transport() string {
+	return o.name
 }
 
 func (o xhrStreamingOptions) writePrelude(w io.Writer) error {
@@ -176,23 +245,6 @@ func (o xhrStreamingOptions) streaming() bool {
 	return true
 }
 
-func xhrJsessionid(r *Router, w http.ResponseWriter, req *http.Request) {
-	c, err := req.Cookie("JSESSIONID")
-	if err == nil && c != nil {
-		if len(c.Path) == 0 {
-			c.Path = "/"
-		}
-		http.SetCookie(w, c)
-	} else if r.CookieNeeded {
-		c := &http.Cookie{
-			Name:  "JSESSIONID",
-			Value: "dummy",
-			Path:  "/",
-		}
-		http.SetCookie(w, c)
-	}
-}
-
 // BUG(mrlauer): xhr connections cannot be reused.
 
 // The handlers
@@ -200,8 +252,9 @@ func xhrHandlerBase(opts xhrOptions, r *Router, w http.ResponseWriter, req *http
 	if xhrProlog(w, req) {
 		return
 	}
-	xhrJsessionid(r, w, req)
+	writeSessionCookie(r, w, req)
 	w.Header().Set("Content-type", opts.contentType())
+	writeNoSniff(w)
 	// For CORS, if the server sent Access-Control-Request-Headers, we
 	// echo it back.
 	acrh := req.Header.Get("Access-Control-Request-Headers")
@@ -209,14 +262,20 @@ func xhrHandlerBase(opts xhrOptions, r *Router, w http.ResponseWriter, req *http
 		w.Header().Set("Access-Control-Allow-Headers", acrh)
 	}
 	sessionId := mux.Vars(req)["sessionid"]
+	remote := r.remoteHost(req)
 
 	w.WriteHeader(http.StatusOK)
 	opts.writePrelude(w)
-	hijackAndContinue(w, func(w io.WriteCloser, done chan struct{}) {
+	hijackAndContinue(w, r.config.WriteTimeout, func(w io.WriteCloser, done chan struct{}) {
 		defer w.Close()
 		var trans *xhrTransport
 		// Find the session
-		s, _ := r.getOrCreateSession(sessionId)
+		s, _, err := r.getOrCreateSession(sessionId, remote)
+		if err != nil {
+			opts.writeFrame(w, openFrame())
+			opts.writeFrame(w, refusalFrame(err))
+			return
+		}
 		s.sessionLock.Lock()
 		// TODO: encapsulate this logic
 		var sessionUnlocked bool
@@ -227,7 +286,7 @@ func xhrHandlerBase(opts xhrOptions, r *Router, w http.ResponseWriter, req *http
 		}()
 		if s.trans != nil {
 			if s.closed {
-				s.trans.writeFrame(w, closeFrame(3000, "Go away!"))
+				s.trans.writeFrame(w, s.closingFrame())
 				return
 			}
 			var ok bool
@@ -239,11 +298,13 @@ func xhrHandlerBase(opts xhrOptions, r *Router, w http.ResponseWriter, req *http
 		} else {
 			trans = new(xhrTransport)
 			trans.opts = opts
+			if es, ok := opts.(eventsourceOptions); ok && es.retry > 0 {
+				trans.events = &eventHistory{opts: es}
+			}
 			s.trans = trans
 			trans.s = s
 			trans.writeFrame(w, openFrame())
-			conn := &Conn{s}
-			go r.handler(conn)
+			go r.handler(s.conn)
 			if !opts.streaming() {
 				w.Close()
 				return
@@ -265,21 +326,27 @@ func xhrHandlerBase(opts xhrOptions, r *Router, w http.ResponseWriter, req *http
 				return
 			}
 		}()
-		err := trans.setReceiver(&xhrReceiver{t: trans, w: w, opts: opts, closed: recvDone})
+		recv := &xhrReceiver{t: trans, w: w, opts: opts, closed: recvDone}
+		err = trans.setReceiver(recv, req.Header.Get("Last-Event-ID"))
 		if err != nil {
 			return
 		}
-		defer trans.clearReceiver()
+		defer trans.clearReceiver(recv)
+		if so, ok := opts.(streamOptions); ok && so.maxDuration() > 0 {
+			timer := time.AfterFunc(so.maxDuration(), recv.rotate)
+			defer timer.Stop()
+		}
 		// The session may already have closed from underneath us!
 		// If so, die now
-		if s.closed {
+		if s.isClosed() {
 			return
 		}
 		<-loopDone
 		// If the session isn't closed, and we're not closing voluntarily, then
-		// assume the client closed us and close the session.
-		if !leavingVoluntarily && !s.closed {
-			trans.clearReceiver()
+		// assume the client closed us and close the session. A resumable
+		// eventsource session waits for the client to reconnect instead.
+		if !leavingVoluntarily && !s.isClosed() && trans.events == nil {
+			trans.clearReceiver(recv)
 			s.Close()
 			r.removeSession(sessionId, s)
 		}
@@ -298,6 +365,7 @@ func xhrSendHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 		http.NotFoundHandler().ServeHTTP(w, req)
 		return
 	}
+	writeSessionCookie(r, w, req)
 	// Synchronization? What if an xhr request is still creating this?
 	buf := bytes.NewBuffer(nil)
 	io.Copy(buf, req.Body)
@@ -308,17 +376,28 @@ func xhrSendHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 	}
 	err := s.fromClient(message(buf.Bytes()))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), sendErrorStatus(err))
 		return
 	}
 	w.Header().Set("Content-length", "0")
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// sendErrorStatus is the status for a send that the session refused.
+func sendErrorStatus(err error) int {
+	switch err {
+	case RateLimited:
+		return http.StatusTooManyRequests
+	case BatchTooLarge:
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
 func xhrHandler(r *Router, w http.ResponseWriter, req *http.Request) {
 	xhrHandlerBase(xhrPollingOptions{}, r, w, req)
 }
 
 func xhrStreamingHandler(r *Router, w http.ResponseWriter, req *http.Request) {
-	xhrHandlerBase(xhrStreamingOptions{}, r, w, req)
+	xhrHandlerBase(xhrStreamingOptions{streamLimit: streamLimit{"xhr_streaming", r.config.XhrStreamingLimit}}, r, w, req)
 }
@@ -1,9 +1,12 @@
 package gosockjs
 
 import (
+	"bufio"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -240,9 +243,10 @@ func TestXhrStreamingSimple(t *testing.T) {
 }
 
 func TestXhrHeartbeat(t *testing.T) {
-	server, baseUrl := startEchoServer()
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.HeartbeatDelay = time.Millisecond * 2
+	})
 	defer server.Close()
-	server.Router.HeartbeatDelay = time.Millisecond * 2
 
 	// Hack up server/session
 	turl := baseUrl + "/123/456"
@@ -288,9 +292,10 @@ func TestXhrHeartbeat(t *testing.T) {
 }
 
 func TestXhrTimeout(t *testing.T) {
-	server, baseUrl := startEchoServer()
+	server, baseUrl := startEchoServer(func(c *Config) {
+		c.DisconnectDelay = time.Millisecond * 5
+	})
 	defer server.Close()
-	server.Router.DisconnectDelay = time.Millisecond * 5
 	start := time.Now()
 
 	// Hack up server/session
@@ -341,3 +346,90 @@ func TestXhrTimeout(t *testing.T) {
 	}
 
 }
+
+// smallBufferListener shrinks the send buffer of the first connection it
+// accepts, so that it stalls sooner if the client stops reading.
+type smallBufferListener struct {
+	net.Listener
+	accepted bool
+}
+
+func (l *smallBufferListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if tc, ok := c.(*net.TCPConn); ok && !l.accepted {
+		tc.SetWriteBuffer(4096)
+		l.accepted = true
+	}
+	return c, err
+}
+
+func TestXhrStalledReceiver(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	r, err := NewRouter("/stall", func(c *Conn) {
+		conns <- c
+		c.ReadMessage()
+	}, func(c *Config) {
+		c.WriteTimeout = 100 * time.Millisecond
+		c.XhrStreamingLimit = StreamLimit{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(r)
+	server.Listener = &smallBufferListener{Listener: server.Listener}
+	server.Start()
+	defer server.Close()
+	surl := server.URL + "/stall/000/stall/xhr_streaming"
+
+	// A client that opens a receiver and never reads it.
+	stalled, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	stalled.(*net.TCPConn).SetReadBuffer(4096)
+	io.WriteString(stalled, "POST /stall/000/stall/xhr_streaming HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n")
+	c := <-conns
+
+	// More than the connection can buffer.
+	big := strings.Repeat("x", 1<<20)
+	written := make(chan bool)
+	go func() {
+		c.Write([]byte(big))
+		written <- true
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// Another writer waits for the stalled one, but not forever.
+	go func() {
+		c.Write([]byte("small"))
+		written <- true
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-written:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Write blocked by a stalled receiver")
+		}
+	}
+
+	// The next receiver gets what the stalled one did not.
+	req, _ := http.NewRequest("POST", surl, nil)
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if prelude, err := br.ReadString('\n'); err != nil || len(prelude) != 2049 {
+		t.Fatalf("Prelude was %d bytes, %v", len(prelude), err)
+	}
+	frame, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(frame, "a") {
+		t.Fatalf("Frame was %.20q, %v", frame, err)
+	}
+	var msgs []string
+	if err := json.Unmarshal([]byte(frame[1:]), &msgs); err != nil || len(msgs) != 2 || msgs[0] != big || msgs[1] != "small" {
+		t.Errorf("Got %d messages, %v", len(msgs), err)
+	}
+}